@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	hm "github.com/sprsquish/housemetrics/pkg"
+	"github.com/sprsquish/housemetrics/pkg/looper"
+)
+
+var backfillFrom, backfillTo, backfillLooper string
+var backfillOpts looper.BackfillOptions
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Load historical data into the configured store",
+}
+
+var backfillFlumeCmd = &cobra.Command{
+	Use:   "flume",
+	Short: "Backfill Flume water usage",
+	RunE:  backfillFlume,
+
+	SilenceUsage: true,
+}
+
+func init() {
+	flags := backfillFlumeCmd.Flags()
+	flags.StringVar(&backfillFrom, "from", "", "Start of the range (2006-01-02 or RFC3339)")
+	flags.StringVar(&backfillTo, "to", "", "End of the range (2006-01-02 or RFC3339), defaults to now")
+	flags.StringVar(&backfillLooper, "looper", "flume", "Flume looper or instance whose settings to use")
+	flags.DurationVar(&backfillOpts.Page, "backfill.page", 12*time.Hour, "Span of each query")
+	flags.DurationVar(&backfillOpts.Pause, "backfill.pause", 30*time.Second, "Wait between queries")
+	flags.DurationVar(&backfillOpts.Retry.Min, "backfill.backoff.min", 30*time.Second, "Initial wait after a failed query")
+	flags.DurationVar(&backfillOpts.Retry.Max, "backfill.backoff.max", 10*time.Minute, "Max wait between failed queries")
+	flags.IntVar(&backfillOpts.Retry.Threshold, "backfill.maxFailures", 10, "Consecutive failed queries before giving up")
+	backfillFlumeCmd.MarkFlagRequired("from")
+
+	backfillCmd.AddCommand(backfillFlumeCmd)
+	mainCmd.AddCommand(backfillCmd)
+}
+
+func backfillFlume(cmd *cobra.Command, args []string) error {
+	from, err := parseBackfillTime(backfillFrom)
+	if err != nil {
+		return err
+	}
+
+	to := time.Now()
+	if backfillTo != "" {
+		if to, err = parseBackfillTime(backfillTo); err != nil {
+			return err
+		}
+	}
+
+	idx := slices.IndexFunc(loopRunners, func(r *hm.LoopRunner) bool { return r.Name() == backfillLooper })
+	if idx < 0 {
+		return fmt.Errorf("unknown looper: %s", backfillLooper)
+	}
+	runner := loopRunners[idx]
+
+	flume, ok := runner.Looper().(*looper.Flume)
+	if !ok {
+		return fmt.Errorf("%s is not a flume looper", backfillLooper)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if !factory.State.Persistent() {
+		log.Warn("no --state.dir set, an interrupted backfill will start over")
+	}
+
+	// a full queue must hold up the backfill, not drop its points
+	storage.Block()
+	storage.Init()
+	defer storage.Close()
+
+	flume.Init()
+	log.Info("starting backfill", "looper", backfillLooper, "from", from, "to", to)
+	if err := flume.Backfill(ctx, runner.Tagged(storage), from, to, backfillOpts); err != nil {
+		return err
+	}
+	log.Info("backfill complete")
+	return nil
+}
+
+func parseBackfillTime(val string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339, val); err == nil {
+		return ts, nil
+	}
+	ts, err := time.ParseInLocation(time.DateOnly, val, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use 2006-01-02 or RFC3339", val)
+	}
+	return ts, nil
+}
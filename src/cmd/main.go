@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	hm "github.com/sprsquish/housemetrics/pkg"
 	"github.com/sprsquish/housemetrics/pkg/endpoint"
 	"github.com/sprsquish/housemetrics/pkg/looper"
@@ -34,48 +39,185 @@ var log = slog.New(slogor.NewHandler(
 	slogor.SetTimeFormat(time.RFC3339),
 ))
 
-var storage store.Client
+var storage *store.MultiClient
 
 var mainCmd = &cobra.Command{
-	Run:           run,
-	SilenceErrors: true,
+	PersistentPreRunE: prepareFlags,
+	Run:               run,
+	SilenceErrors:     true,
 }
 
 var loopRunners []*hm.LoopRunner
+var secrets *hm.Secrets
+var factory *hm.RunnerFactory
 var muxer = http.NewServeMux()
 var httpAddr string
+var configPath string
+var instances []string
+var readyMaxFailing time.Duration
+var stats = hm.NewStats()
+var statsInterval time.Duration
+var adminToken string
+var dryRun bool
+var secretsInterval time.Duration
+var influx *store.InfluxClient
+var influxFlags []string
+
+type looperType struct {
+	name    string
+	freq    time.Duration
+	factory hm.LooperFactory
+}
+
+var looperTypes = []looperType{
+	{"awair", 5 * time.Minute, looper.NewAwair},
+	{"ambientWeather", 1 * time.Minute, looper.NewAmbientWeather},
+	{"flume", 1 * time.Minute, looper.NewFlume},
+	{"particle", 1 * time.Minute, looper.NewParticle},
+	{"updatedns", 1 * time.Minute, looper.NewUpdateDNS},
+	{"purpleair", 1 * time.Minute, looper.NewPurpleAir},
+	{"eagle", 30 * time.Second, looper.NewEagle},
+}
 
 func init() {
-	flags := mainCmd.Flags()
+	flags := mainCmd.PersistentFlags()
 	flags.StringVar(&httpAddr, "http.addr", ":7777", "Listen address")
 	flags.BoolVar(&leveler.debug, "debug", false, "debug mode")
+	flags.StringVar(&configPath, "config", "", "YAML config file; explicit flags take precedence")
+	flags.StringSliceVar(&instances, "instances", nil, "Additional looper instances: 'type.name'")
+	flags.DurationVar(&readyMaxFailing, "ready.maxFailing", 30*time.Minute, "Fail readiness once a looper has been failing this long (0 disables)")
+	flags.BoolVar(&dryRun, "dry-run", false, "Record and summarize writes instead of storing them")
+	flags.StringVar(&adminToken, "admin.token", "", "Bearer token required by the /admin API (empty disables it)")
+	flags.DurationVar(&statsInterval, "stats.interval", time.Minute, "How often to write housemetrics.* self metrics")
+	flags.DurationVar(&secretsInterval, "secrets.interval", time.Minute, "How often to check file secrets used by the store (0 disables)")
 
-	storage = store.NewInfluxClient(flags, log)
+	prom := store.NewPromClient(flags, log)
+	influxFlags = hm.AddedFlags(flags, func() {
+		influx = store.NewInfluxClient(flags, log)
+	})
+	storage = store.NewMultiClient(flags, log, map[string]store.Client{
+		"influxdb":   influx,
+		"prometheus": prom,
+		"log":        store.NewLogStore(log),
+		"dryrun":     store.NewDryRunClient(flags, log),
+	})
+	storage.Observe(func(backend string, took time.Duration, points int, err error) {
+		tags := map[string]string{"backend": backend}
+		stats.Observe("store.write.duration", took, tags)
+		if err != nil {
+			stats.Inc("store.write.errors", tags)
+		}
+	})
+	stats.Collect(func() []hm.Sample {
+		var samples []hm.Sample
+		for key, val := range storage.Counters() {
+			backend, counter, _ := strings.Cut(key, ".")
+			samples = append(samples, hm.Sample{
+				Name:  "store." + counter,
+				Value: val,
+				Tags:  map[string]string{"backend": backend},
+			})
+		}
+		return samples
+	})
 
-	f := &hm.RunnerFactory{
-		Flags:  flags,
-		Client: hm.NewHttpClient(),
-		Logger: log,
-		Store:  storage,
-	}
+	secrets = hm.NewSecrets(flags, log)
 
-	loopRunners = []*hm.LoopRunner{
-		f.MakeLooper("awair", 5*time.Minute, looper.NewAwair),
-		f.MakeLooper("ambientWeather", 1*time.Minute, looper.NewAmbientWeather),
-		f.MakeLooper("flume", 1*time.Minute, looper.NewFlume),
-		f.MakeLooper("particle", 1*time.Minute, looper.NewParticle),
-		f.MakeLooper("updatedns", 1*time.Minute, looper.NewUpdateDNS),
-		f.MakeLooper("purpleair", 1*time.Minute, looper.NewPurpleAir),
+	factory = &hm.RunnerFactory{
+		Flags:   flags,
+		Client:  hm.NewHttpClient(),
+		Logger:  log,
+		Store:   storage,
+		Secrets: secrets,
+		Stats:   stats,
+		State:   hm.NewState(flags),
 	}
 
-	muxer.Handle("/rainforest", f.MakeHandler("rainforest", endpoint.NewRainforest))
-	muxer.Handle("/rachio/webhook", f.MakeHandler("rachio", endpoint.NewRachio))
+	muxer.Handle("/rainforest", factory.MakeHandler("rainforest", endpoint.NewRainforest))
+	muxer.Handle("/rachio/webhook", factory.MakeHandler("rachio", endpoint.NewRachio))
+	muxer.Handle("/metrics", prom)
+	muxer.Handle("/debug/metrics", stats)
 }
 
 func main() {
-	if err := mainCmd.Execute(); err != nil {
+	declared, err := scanInstances(os.Args[1:])
+	if err == nil {
+		err = registerLoopers(declared)
+	}
+	if err == nil {
+		err = mainCmd.Execute()
+	}
+	if err != nil {
 		log.Error("failed to start", "err", err)
+		os.Exit(1)
+	}
+}
+
+// scanInstances finds the declared instances ahead of the full flag parse,
+// since each instance registers flags of its own.
+func scanInstances(args []string) ([]string, error) {
+	boot := pflag.NewFlagSet("boot", pflag.ContinueOnError)
+	boot.ParseErrorsWhitelist.UnknownFlags = true
+	boot.SetOutput(io.Discard)
+	path := boot.String("config", "", "")
+	declared := boot.StringSlice("instances", nil, "")
+
+	// Errors (including --help) are reported by the real parse.
+	boot.Parse(args)
+
+	if boot.Changed("instances") || *path == "" {
+		return *declared, nil
+	}
+
+	values, err := hm.LoadConfig(*path)
+	if err != nil {
+		return nil, err
+	}
+	return values["instances"], nil
+}
+
+func registerLoopers(declared []string) error {
+	for _, instance := range declared {
+		typeName, instName, _ := strings.Cut(instance, ".")
+		if instName == "" || strings.Contains(instName, ".") {
+			return fmt.Errorf("invalid instance %q: expected 'type.name'", instance)
+		}
+		if !slices.ContainsFunc(looperTypes, func(t looperType) bool { return t.name == typeName }) {
+			return fmt.Errorf("invalid instance %q: unknown looper type %q", instance, typeName)
+		}
+	}
+
+	for _, t := range looperTypes {
+		loopRunners = append(loopRunners, factory.MakeLoopers(t.name, declared, t.freq, t.factory)...)
 	}
+	return nil
+}
+
+// prepareFlags layers the config file under explicit flags, then resolves any
+// env: or file: secret references.
+func prepareFlags(cmd *cobra.Command, args []string) error {
+	if configPath != "" {
+		values, err := hm.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if err := hm.ApplyConfig(cmd.Flags(), values); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		// Set would append to a --store given on the command line
+		flag := cmd.Flags().Lookup("store")
+		if flag.Changed || flag.Value.String() != flag.DefValue {
+			log.Warn("dry run overrides the configured store", "store", flag.Value.String())
+		}
+		if err := flag.Value.(pflag.SliceValue).Replace([]string{"dryrun"}); err != nil {
+			return err
+		}
+	}
+
+	return secrets.Resolve()
 }
 
 func run(cmd *cobra.Command, args []string) {
@@ -95,6 +237,25 @@ func run(cmd *cobra.Command, args []string) {
 		}(runner)
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stats.Run(ctx, storage, statsInterval)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secrets.Watch(ctx, secretsInterval, influxFlags, influx.Reload)
+	}()
+
+	health := hm.NewHealth(loopRunners, storage, readyMaxFailing)
+	muxer.HandleFunc("/healthz", health.Healthz)
+	muxer.HandleFunc("/readyz", health.Readyz)
+	if !hm.NewAdmin(loopRunners, adminToken).Register(muxer) {
+		log.Warn("admin API disabled, set --admin.token to enable it")
+	}
+
 	server := http.Server{
 		Addr:    httpAddr,
 		Handler: muxer,
@@ -120,5 +281,8 @@ func run(cmd *cobra.Command, args []string) {
 	log.Info("waiting for pollers to stop")
 	wg.Wait()
 
+	log.Info("flushing store")
+	storage.Close()
+
 	log.Info("stopped")
 }
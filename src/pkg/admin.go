@@ -0,0 +1,126 @@
+package housemetrics
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Admin exposes runner control over HTTP:
+//
+//	GET  /admin/loopers
+//	GET  /admin/loopers/{name}
+//	POST /admin/loopers/{name}/poll
+//	POST /admin/loopers/{name}/pause
+//	POST /admin/loopers/{name}/resume
+//	POST /admin/loopers/{name}/freq?value=30s
+type Admin struct {
+	runners map[string]*LoopRunner
+	order   []*LoopRunner
+	token   string
+}
+
+// NewAdmin requires "Authorization: Bearer <token>" on every request.
+func NewAdmin(runners []*LoopRunner, token string) *Admin {
+	a := &Admin{
+		runners: make(map[string]*LoopRunner, len(runners)),
+		order:   runners,
+		token:   token,
+	}
+	for _, runner := range runners {
+		a.runners[runner.Name()] = runner
+	}
+	return a
+}
+
+// Register mounts the admin routes. Without a token it mounts nothing, since
+// the listener is shared with webhooks that must be reachable publicly.
+func (a *Admin) Register(mux *http.ServeMux) bool {
+	if a.token == "" {
+		return false
+	}
+
+	mux.HandleFunc("GET /admin/loopers", a.auth(a.list))
+	mux.HandleFunc("GET /admin/loopers/{name}", a.auth(a.withRunner(a.show)))
+	mux.HandleFunc("POST /admin/loopers/{name}/poll", a.auth(a.withRunner(a.poll)))
+	mux.HandleFunc("POST /admin/loopers/{name}/pause", a.auth(a.withRunner(a.pause)))
+	mux.HandleFunc("POST /admin/loopers/{name}/resume", a.auth(a.withRunner(a.resume)))
+	mux.HandleFunc("POST /admin/loopers/{name}/freq", a.auth(a.withRunner(a.freq)))
+	return true
+}
+
+func (a *Admin) list(w http.ResponseWriter, req *http.Request) {
+	statuses := make([]RunnerStatus, len(a.order))
+	for i, runner := range a.order {
+		statuses[i] = runner.Status()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (a *Admin) show(w http.ResponseWriter, req *http.Request, runner *LoopRunner) {
+	writeJSON(w, http.StatusOK, runner.Status())
+}
+
+func (a *Admin) poll(w http.ResponseWriter, req *http.Request, runner *LoopRunner) {
+	if err := runner.Trigger(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, runner.Status())
+}
+
+func (a *Admin) pause(w http.ResponseWriter, req *http.Request, runner *LoopRunner) {
+	runner.Pause()
+	writeJSON(w, http.StatusOK, runner.Status())
+}
+
+func (a *Admin) resume(w http.ResponseWriter, req *http.Request, runner *LoopRunner) {
+	runner.Resume()
+	writeJSON(w, http.StatusOK, runner.Status())
+}
+
+func (a *Admin) freq(w http.ResponseWriter, req *http.Request, runner *LoopRunner) {
+	freq, err := time.ParseDuration(req.URL.Query().Get("value"))
+	if err == nil {
+		err = runner.SetFreq(freq)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runner.Status())
+}
+
+func (a *Admin) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		given := []byte(req.Header.Get("Authorization"))
+		want := []byte("Bearer " + a.token)
+		if a.token == "" || subtle.ConstantTimeCompare(given, want) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (a *Admin) withRunner(next func(http.ResponseWriter, *http.Request, *LoopRunner)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		runner, ok := a.runners[req.PathValue("name")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		next(w, req, runner)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
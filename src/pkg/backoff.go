@@ -0,0 +1,53 @@
+package housemetrics
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides how long a runner waits after consecutive failed polls.
+type RetryPolicy struct {
+	Min time.Duration
+	Max time.Duration
+
+	// After Threshold consecutive failures the circuit opens and the runner
+	// waits Cooldown between attempts. A zero Threshold disables it.
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// Delay returns the wait after the given number of consecutive failures,
+// ending in err, and whether the circuit is open.
+func (p *RetryPolicy) Delay(failures int, err error) (time.Duration, bool) {
+	open := p.Threshold > 0 && failures >= p.Threshold
+
+	var delay time.Duration
+	if open {
+		delay = p.Cooldown
+	} else {
+		delay = p.backoff(failures)
+	}
+
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) && reqErr.RetryAfter > delay {
+		delay = reqErr.RetryAfter
+	}
+
+	return delay, open
+}
+
+// backoff doubles Min for every failure up to Max, then picks a random point
+// in the upper half of that window.
+func (p *RetryPolicy) backoff(failures int) time.Duration {
+	delay := p.Min
+	for i := 1; i < failures && delay < p.Max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.Max)
+
+	if half := delay / 2; half > 0 {
+		delay = half + rand.N(half)
+	}
+	return delay
+}
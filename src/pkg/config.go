@@ -0,0 +1,115 @@
+package housemetrics
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig reads a YAML config file and flattens its nested sections into
+// the dotted flag namespace, so
+//
+//	flume:
+//	  enabled: true
+//	  clientID: abc
+//
+// yields "flume.enabled" and "flume.clientID".
+func LoadConfig(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string][]string{}
+	if err := flattenConfig("", raw, values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// ApplyConfig sets every flag named in values that wasn't given explicitly on
+// the command line. Keys that don't match a flag are reported as errors.
+func ApplyConfig(flags *pflag.FlagSet, values map[string][]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var errs []error
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil {
+			errs = append(errs, fmt.Errorf("unknown config key: %s", key))
+			continue
+		}
+
+		if flag.Changed {
+			continue
+		}
+
+		vals := values[key]
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(vals); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+			flag.Changed = true
+			continue
+		}
+
+		if len(vals) != 1 {
+			errs = append(errs, fmt.Errorf("%s: expected a single value", key))
+			continue
+		}
+
+		if err := flags.Set(key, vals[0]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func flattenConfig(prefix string, raw map[string]any, values map[string][]string) error {
+	for k, v := range raw {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch val := v.(type) {
+		case map[string]any:
+			if err := flattenConfig(key, val, values); err != nil {
+				return err
+			}
+
+		case []any:
+			vals := make([]string, 0, len(val))
+			for _, item := range val {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s: lists may only contain scalar values", key)
+				}
+				vals = append(vals, fmt.Sprint(item))
+			}
+			values[key] = vals
+
+		case nil:
+			return fmt.Errorf("%s: missing value", key)
+
+		default:
+			values[key] = []string{fmt.Sprint(val)}
+		}
+	}
+
+	return nil
+}
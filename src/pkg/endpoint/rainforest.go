@@ -2,17 +2,90 @@ package endpoint
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/sprsquish/housemetrics/pkg/store"
 )
 
+// Fragments posted by the Eagle uploader. Numbers are 0x-prefixed hex and
+// metered values are value * Multiplier / Divisor.
+type eagleHeader struct {
+	DeviceMacId string
+	MeterMacId  string
+	TimeStamp   string
+}
+
+// Eagle timestamps count seconds from the Zigbee epoch.
+var zigbeeEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// time returns when the reading was taken, or now if the fragment has no
+// timestamp.
+func (h eagleHeader) time() (time.Time, error) {
+	if h.TimeStamp == "" {
+		return time.Now(), nil
+	}
+
+	secs, err := parseHex(h.TimeStamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return zigbeeEpoch.Add(time.Duration(secs) * time.Second), nil
+}
+
+// tags identifies the Eagle and meter a reading came from.
+func (h eagleHeader) tags() map[string]string {
+	tags := map[string]string{}
+	if h.DeviceMacId != "" {
+		tags["device_mac_id"] = h.DeviceMacId
+	}
+	if h.MeterMacId != "" {
+		tags["meter_mac_id"] = h.MeterMacId
+	}
+	return tags
+}
+
+type instantaneousDemand struct {
+	eagleHeader
+	Demand     string
+	Multiplier string
+	Divisor    string
+}
+
+type currentSummation struct {
+	eagleHeader
+	SummationDelivered string
+	SummationReceived  string
+	Multiplier         string
+	Divisor            string
+}
+
+type priceCluster struct {
+	eagleHeader
+	Price          string
+	Currency       string
+	TrailingDigits string
+	Tier           string
+}
+
+// connectionStatus is also used for NetworkInfo, which carries the same
+// status fields.
+type connectionStatus struct {
+	eagleHeader
+	Status       string
+	Channel      string
+	LinkStrength string
+}
+
 type Rainforest struct {
 	logger *slog.Logger
 	store  store.Client
@@ -30,31 +103,198 @@ func (r *Rainforest) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	var reading struct {
-		InstantaneousDemand struct {
-			TimeStamp string
-			Demand    string
-		}
+		InstantaneousDemand       *instantaneousDemand
+		CurrentSummationDelivered *currentSummation
+		CurrentSummationReceived  *currentSummation
+		PriceCluster              *priceCluster
+		ConnectionStatus          *connectionStatus
+		NetworkInfo               *connectionStatus
 	}
 	err := xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&reading)
 	if err != nil {
 		r.logger.Error("reading decode error", "err", err, "reading", bodyBytes)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if reading.InstantaneousDemand.Demand == "" {
+	ctx := req.Context()
+	switch {
+	case reading.InstantaneousDemand != nil:
+		err = r.writeDemand(ctx, reading.InstantaneousDemand)
+	case reading.CurrentSummationDelivered != nil:
+		err = r.writeSummation(ctx, reading.CurrentSummationDelivered)
+	case reading.CurrentSummationReceived != nil:
+		err = r.writeSummation(ctx, reading.CurrentSummationReceived)
+	case reading.PriceCluster != nil:
+		err = r.writePrice(ctx, reading.PriceCluster)
+	case reading.ConnectionStatus != nil:
+		err = r.writeConnection(ctx, reading.ConnectionStatus)
+	case reading.NetworkInfo != nil:
+		err = r.writeConnection(ctx, reading.NetworkInfo)
+	default:
+		r.logger.Debug("ignoring reading", "reading", bodyBytes)
+	}
+	if err != nil {
+		r.logger.Error("reading parse error", "err", err, "reading", bodyBytes)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	tsStr := reading.InstantaneousDemand.TimeStamp[2:]
-	tsInt, _ := strconv.ParseInt(tsStr, 16, 64)
-	ts := time.Unix(tsInt, 0).AddDate(30, 0, 0)
+	w.WriteHeader(http.StatusOK)
+}
 
-	wattsStr := reading.InstantaneousDemand.Demand[2:]
-	watts, _ := strconv.ParseInt(wattsStr, 16, 64)
+func (r *Rainforest) writeDemand(ctx context.Context, d *instantaneousDemand) error {
+	ts, err := d.time()
+	if err != nil {
+		return err
+	}
 
-	// int32 overflows to negative and influx expects a float
-	val := float32(int32(watts))
-	r.store.Write(req.Context(), ts, "watts", val, nil)
+	raw, err := parseHex(d.Demand)
+	if err != nil {
+		return err
+	}
 
-	w.WriteHeader(http.StatusOK)
+	// negative while exporting
+	kw, err := scaleReading(float64(int32(raw)), d.Multiplier, d.Divisor)
+	if err != nil {
+		return err
+	}
+
+	r.store.Write(ctx, ts, "watts", kw*1000, d.tags())
+	return nil
+}
+
+func (r *Rainforest) writeSummation(ctx context.Context, s *currentSummation) error {
+	ts, err := s.time()
+	if err != nil {
+		return err
+	}
+
+	values := map[string]float64{}
+	for name, val := range map[string]string{
+		"rainforest.summation_delivered": s.SummationDelivered,
+		"rainforest.summation_received":  s.SummationReceived,
+	} {
+		if val == "" {
+			continue
+		}
+
+		raw, err := parseHex(val)
+		if err != nil {
+			return err
+		}
+		kwh, err := scaleReading(float64(raw), s.Multiplier, s.Divisor)
+		if err != nil {
+			return err
+		}
+		values[name] = kwh
+	}
+
+	// only write once the whole fragment parsed
+	for name, kwh := range values {
+		r.store.Write(ctx, ts, name, kwh, s.tags())
+	}
+	return nil
+}
+
+func (r *Rainforest) writePrice(ctx context.Context, p *priceCluster) error {
+	ts, err := p.time()
+	if err != nil {
+		return err
+	}
+
+	raw, err := parseHex(p.Price)
+	if err != nil {
+		return err
+	}
+
+	var digits uint64
+	if p.TrailingDigits != "" {
+		if digits, err = parseHex(p.TrailingDigits); err != nil {
+			return err
+		}
+	}
+
+	tags := p.tags()
+	if p.Currency != "" {
+		currency, err := parseHex(p.Currency)
+		if err != nil {
+			return err
+		}
+		tags["currency"] = strconv.FormatUint(currency, 10)
+	}
+	if p.Tier != "" {
+		tier, err := parseHex(p.Tier)
+		if err != nil {
+			return err
+		}
+		tags["tier"] = strconv.FormatUint(tier, 10)
+	}
+
+	price := float64(raw) / math.Pow10(int(digits))
+	r.store.Write(ctx, ts, "rainforest.price", price, tags)
+	return nil
+}
+
+func (r *Rainforest) writeConnection(ctx context.Context, c *connectionStatus) error {
+	ts, err := c.time()
+	if err != nil {
+		return err
+	}
+
+	var strength uint64
+	if c.LinkStrength != "" {
+		if strength, err = parseHex(c.LinkStrength); err != nil {
+			return err
+		}
+	}
+
+	connected := 0
+	if c.Status == "Connected" {
+		connected = 1
+	}
+
+	tags := c.tags()
+	r.store.Write(ctx, ts, "rainforest.connected", connected, tags)
+	if c.LinkStrength != "" {
+		r.store.Write(ctx, ts, "rainforest.link_strength", strength, tags)
+	}
+	return nil
+}
+
+// defaultDivisor applies when a fragment has no Divisor. Unscaled readings
+// have always been stored as watts, i.e. thousandths of a kW.
+const defaultDivisor = 1000
+
+// scaleReading applies a Multiplier and Divisor. A zero factor counts as 1.
+func scaleReading(val float64, multiplier, divisor string) (float64, error) {
+	m, err := scaleFactor(multiplier, 1)
+	if err != nil {
+		return 0, err
+	}
+	d, err := scaleFactor(divisor, defaultDivisor)
+	if err != nil {
+		return 0, err
+	}
+	return val * m / d, nil
+}
+
+func scaleFactor(hex string, missing float64) (float64, error) {
+	if hex == "" {
+		return missing, nil
+	}
+	n, err := parseHex(hex)
+	if err != nil || n == 0 {
+		return 1, err
+	}
+	return float64(n), nil
+}
+
+// parseHex reads the 0x-prefixed hex numbers the Eagle sends.
+func parseHex(s string) (uint64, error) {
+	digits, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(s)), "0x")
+	if !ok || digits == "" {
+		return 0, fmt.Errorf("malformed hex value %q", s)
+	}
+	return strconv.ParseUint(digits, 16, 64)
 }
@@ -0,0 +1,75 @@
+package housemetrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+type Health struct {
+	runners    []*LoopRunner
+	store      store.Client
+	maxFailing time.Duration
+}
+
+type healthReport struct {
+	Ready   bool           `json:"ready"`
+	Reasons []string       `json:"reasons,omitempty"`
+	Loopers []RunnerStatus `json:"loopers"`
+}
+
+// NewHealth reports runner status. Readiness fails when the store can't be
+// reached or an enabled runner has been failing for longer than maxFailing.
+func NewHealth(runners []*LoopRunner, store store.Client, maxFailing time.Duration) *Health {
+	return &Health{
+		runners:    runners,
+		store:      store,
+		maxFailing: maxFailing,
+	}
+}
+
+func (h *Health) Healthz(w http.ResponseWriter, req *http.Request) {
+	report := h.report(req.Context(), false)
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (h *Health) Readyz(w http.ResponseWriter, req *http.Request) {
+	report := h.report(req.Context(), true)
+
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+func (h *Health) report(ctx context.Context, checkStore bool) healthReport {
+	report := healthReport{Ready: true}
+
+	if pinger, ok := h.store.(store.Pinger); ok && checkStore {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		if err := pinger.Ping(ctx); err != nil {
+			report.Reasons = append(report.Reasons, fmt.Sprintf("store unreachable: %s", err))
+		}
+	}
+
+	for _, runner := range h.runners {
+		status := runner.Status()
+		report.Loopers = append(report.Loopers, status)
+
+		if !status.Enabled || status.FailingSince.IsZero() || h.maxFailing <= 0 {
+			continue
+		}
+		if failing := time.Since(status.FailingSince); failing > h.maxFailing {
+			report.Reasons = append(report.Reasons, fmt.Sprintf("%s failing for %s: %s", status.Name, failing.Round(time.Second), status.LastError))
+		}
+	}
+
+	report.Ready = len(report.Reasons) == 0
+	return report
+}
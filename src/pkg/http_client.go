@@ -1,27 +1,62 @@
 package housemetrics
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
+	"time"
 )
 
 var ErrFailedRequest = errors.New("failed request")
 
+// RequestError is returned for non-2xx responses. It matches ErrFailedRequest
+// under errors.Is.
+type RequestError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("failed request: status %d", e.StatusCode)
+}
+
+func (e *RequestError) Is(target error) bool {
+	return target == ErrFailedRequest
+}
+
+func newRequestError(rep *http.Response) *RequestError {
+	return &RequestError{
+		StatusCode: rep.StatusCode,
+		RetryAfter: parseRetryAfter(rep.Header.Get("Retry-After")),
+	}
+}
+
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if ts, err := http.ParseTime(val); err == nil {
+		return time.Until(ts)
+	}
+	return 0
+}
+
 type HttpClient struct {
 	client *http.Client
-}
 
-type Event struct {
-	Type string
-	Data string
+	name  string
+	stats *Stats
 }
 
 func NewHttpClient() *HttpClient {
@@ -30,6 +65,28 @@ func NewHttpClient() *HttpClient {
 	}
 }
 
+// instrumented returns a client sharing c's transport that records response
+// codes under name.
+func (c *HttpClient) instrumented(name string, stats *Stats) *HttpClient {
+	return &HttpClient{
+		client: c.client,
+		name:   name,
+		stats:  stats,
+	}
+}
+
+func (c *HttpClient) do(req *http.Request) (*http.Response, error) {
+	rep, err := c.client.Do(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(rep.StatusCode)
+	}
+	c.stats.Inc("http.responses", map[string]string{"integration": c.name, "code": code})
+
+	return rep, err
+}
+
 func URLOpt(u *url.URL) func(req *http.Request) {
 	return func(req *http.Request) {
 		req.URL = u
@@ -52,13 +109,15 @@ func (c *HttpClient) SendJSON(ctx context.Context, log *slog.Logger, reqData any
 
 	opts(req)
 
-	rep, err := c.client.Do(req)
+	rep, err := c.do(req)
 	if err != nil {
 		return err
 	}
 
+	defer rep.Body.Close()
+
 	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
-		return ErrFailedRequest
+		return newRequestError(rep)
 	}
 
 	bodyBytes, _ := io.ReadAll(rep.Body)
@@ -71,98 +130,67 @@ func (c *HttpClient) SendJSON(ctx context.Context, log *slog.Logger, reqData any
 	return nil
 }
 
-func (c *HttpClient) GetJSON(ctx context.Context, log *slog.Logger, data any, opts func(*http.Request)) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
+func (c *HttpClient) SendXML(ctx context.Context, log *slog.Logger, reqData any, repData any, opts func(*http.Request)) error {
+	reqBytes, err := xml.Marshal(reqData)
 	if err != nil {
 		return err
 	}
+	log.Debug("SendXML req body", "body", string(reqBytes))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "text/xml")
 
 	opts(req)
 
-	rep, err := c.client.Do(req)
+	rep, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer rep.Body.Close()
 
 	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(rep.Body)
-		log.Error("request error", "code", rep.StatusCode, "rep", bodyBytes)
-		return ErrFailedRequest
+		return newRequestError(rep)
 	}
 
 	bodyBytes, _ := io.ReadAll(rep.Body)
-	log.Debug("GetJSON body", "body", string(bodyBytes))
+	log.Debug("SendXML recv body", "body", string(bodyBytes))
 
-	if err := json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(data); err != nil {
-		log.Error("GetJSON decode error", "err", err, "body", string(bodyBytes))
+	if err := xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(repData); err != nil {
+		log.Error("SendXML decode error", "err", err, "body", string(bodyBytes))
 		return err
 	}
 	return nil
 }
 
-func (c *HttpClient) Events(ctx context.Context, log *slog.Logger, opts func(*http.Request)) (chan *Event, error) {
+func (c *HttpClient) GetJSON(ctx context.Context, log *slog.Logger, data any, opts func(*http.Request)) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	opts(req)
 
-	rep, err := c.client.Do(req)
+	rep, err := c.do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer rep.Body.Close()
 
-	errChan := make(chan error)
-	eventChan := make(chan *Event)
-
-	go func() {
-		select {
-		case <-ctx.Done():
-		case err := <-errChan:
-			log.Error("event stream read error", "err", err)
-		}
-
-		rep.Body.Close()
-		close(eventChan)
-	}()
-
-	go func() {
-		defer rep.Body.Close()
-		reader := bufio.NewReader(rep.Body)
-
-		var event *Event
-		for {
-			line, err := reader.ReadString('\n')
-			if line == "\n" {
-				continue
-			}
-
-			log.Debug("Event line", "line", line)
-
-			if err != nil {
-				errChan <- err
-				return
-			}
-
-			if strings.HasPrefix(line, "data: ") && event != nil {
-				event.Data = line[6 : len(line)-1]
-
-				select {
-				case eventChan <- event:
-				default:
-				}
-
-				event = nil
-				continue
-			}
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(rep.Body)
+		log.Error("request error", "code", rep.StatusCode, "rep", bodyBytes)
+		return newRequestError(rep)
+	}
 
-			if strings.HasPrefix(line, "event: ") {
-				event = &Event{Type: line[7 : len(line)-1]}
-			}
-		}
-	}()
+	bodyBytes, _ := io.ReadAll(rep.Body)
+	log.Debug("GetJSON body", "body", string(bodyBytes))
 
-	return eventChan, nil
+	if err := json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(data); err != nil {
+		log.Error("GetJSON decode error", "err", err, "body", string(bodyBytes))
+		return err
+	}
+	return nil
 }
@@ -2,60 +2,268 @@ package housemetrics
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/sprsquish/housemetrics/pkg/store"
 )
 
+// minPollFreq keeps SetFreq from hammering upstream APIs.
+const minPollFreq = time.Second
+
 type Looper interface {
 	Init()
 	Poll(context.Context, store.Client) error
 }
 
+// StatusReporter is implemented by loopers with details worth surfacing in
+// their runner's status.
+type StatusReporter interface {
+	Status() map[string]any
+}
+
+type RunnerStatus struct {
+	Name                string         `json:"name"`
+	Enabled             bool           `json:"enabled"`
+	Running             bool           `json:"running"`
+	Paused              bool           `json:"paused"`
+	PollFreq            string         `json:"pollFreq"`
+	LastSuccess         time.Time      `json:"lastSuccess,omitzero"`
+	FailingSince        time.Time      `json:"failingSince,omitzero"`
+	ConsecutiveFailures int            `json:"consecutiveFailures"`
+	LastError           string         `json:"lastError,omitempty"`
+	Details             map[string]any `json:"details,omitempty"`
+}
+
 type LoopRunner struct {
-	name   string
-	looper Looper
-	logger *slog.Logger
+	name    string
+	looper  Looper
+	logger  *slog.Logger
+	secrets *Secrets
+	owned   []string
+	stats   *Stats
+	tags    map[string]string
 
 	pollFreq time.Duration
 	enabled  bool
+	retry    RetryPolicy
+
+	trigger     chan struct{}
+	freqChanged chan struct{}
+
+	mu     sync.Mutex
+	status RunnerStatus
+}
+
+func (r *LoopRunner) Name() string {
+	return r.name
+}
+
+func (r *LoopRunner) Status() RunnerStatus {
+	r.mu.Lock()
+	status := r.status
+	status.Name = r.name
+	status.Enabled = r.enabled
+	status.PollFreq = r.pollFreq.String()
+	r.mu.Unlock()
+
+	// outside mu, so a slow looper can't hold up the runner
+	if reporter, ok := r.looper.(StatusReporter); ok && status.Running {
+		status.Details = reporter.Status()
+	}
+	return status
+}
+
+func (r *LoopRunner) Enabled() bool {
+	return r.enabled
+}
+
+func (r *LoopRunner) Looper() Looper {
+	return r.looper
 }
 
-func (r *LoopRunner) Run(ctx context.Context, store store.Client) {
+// Tagged wraps client with the runner's instance tags, if it has any.
+func (r *LoopRunner) Tagged(client store.Client) store.Client {
+	if r.tags == nil {
+		return client
+	}
+	return store.WithTags(client, r.tags)
+}
+
+// Once initializes the looper and polls a single time.
+func (r *LoopRunner) Once(ctx context.Context, client store.Client) error {
+	r.looper.Init()
+	return r.looper.Poll(ctx, r.Tagged(client))
+}
+
+// Trigger asks a running runner to poll now, even when paused.
+func (r *LoopRunner) Trigger() error {
+	if !r.Status().Running {
+		return fmt.Errorf("%s is not running", r.name)
+	}
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pause stops scheduled polls until Resume is called.
+func (r *LoopRunner) Pause() {
+	r.setPaused(true)
+}
+
+func (r *LoopRunner) Resume() {
+	r.setPaused(false)
+}
+
+func (r *LoopRunner) SetFreq(freq time.Duration) error {
+	if freq < minPollFreq {
+		return fmt.Errorf("invalid poll frequency: %s (minimum %s)", freq, minPollFreq)
+	}
+
+	r.mu.Lock()
+	r.pollFreq = freq
+	r.mu.Unlock()
+
+	select {
+	case r.freqChanged <- struct{}{}:
+	default:
+	}
+
+	r.logger.Info("poll frequency changed", "freq", freq)
+	return nil
+}
+
+func (r *LoopRunner) setPaused(paused bool) {
+	r.mu.Lock()
+	r.status.Paused = paused
+	r.mu.Unlock()
+
+	r.logger.Info("paused state changed", "paused", paused)
+}
+
+func (r *LoopRunner) setRunning(running bool) {
+	r.mu.Lock()
+	r.status.Running = running
+	r.mu.Unlock()
+}
+
+func (r *LoopRunner) Run(ctx context.Context, client store.Client) {
 	if !r.enabled {
 		r.logger.Info("disabled")
 		return
 	}
 
+	client = r.Tagged(client)
 	r.looper.Init()
 
-	r.logger.Info("starting", "freq", r.pollFreq)
-	ticker := time.NewTicker(r.pollFreq)
+	r.setRunning(true)
+	defer r.setRunning(false)
+
+	status := r.Status()
+	r.logger.Info("starting", "freq", status.PollFreq)
 
-	r.poll(ctx, store)
+	// next fires when the next poll is due. A failed poll pushes it out to the
+	// end of its backoff, which a trigger still cuts short.
+	next := time.NewTimer(0)
+	defer next.Stop()
+	var retryAt time.Time
+
+	pollNow := func() {
+		start := time.Now()
+		delay := r.poll(ctx, client)
+		retryAt = time.Now().Add(delay)
+		next.Reset(max(time.Until(start.Add(r.freq())), delay))
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			ticker.Stop()
 			r.logger.Info("stopping")
 			return
 
-		case <-ticker.C:
-			r.poll(ctx, store)
+		case <-next.C:
+			if r.Status().Paused {
+				next.Reset(r.freq())
+				continue
+			}
+			pollNow()
+
+		case <-r.trigger:
+			r.logger.Info("triggered poll")
+			pollNow()
+
+		case <-r.freqChanged:
+			next.Reset(max(r.freq(), time.Until(retryAt)))
 		}
 	}
 }
 
-func (r *LoopRunner) poll(ctx context.Context, store store.Client) {
+func (r *LoopRunner) freq() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.pollFreq
+}
+
+// poll runs the looper once and returns how long to back off for, which is
+// zero after a successful poll.
+func (r *LoopRunner) poll(ctx context.Context, store store.Client) time.Duration {
+	if r.secrets != nil && r.secrets.Refresh(r.owned) {
+		r.logger.Info("secrets rotated, reinitializing")
+		r.looper.Init()
+	}
+
+	start := time.Now()
 	err := r.looper.Poll(ctx, store)
-	if err != nil {
-		if err != ErrFailedRequest {
-			r.logger.Error("poll error", "err", err)
-		} else {
-			r.logger.Info("failed request.. sleeping")
-			time.Sleep(1 * time.Minute)
+
+	statTags := map[string]string{"looper": r.name}
+	r.stats.Observe("poll.duration", time.Since(start), statTags)
+	if err == nil {
+		r.stats.Inc("poll.success", statTags)
+	} else {
+		r.stats.Inc("poll.failure", statTags)
+	}
+
+	failures := r.recordPoll(err)
+	if err == nil {
+		return 0
+	}
+
+	delay, open := r.retry.Delay(failures, err)
+
+	if errors.Is(err, ErrFailedRequest) {
+		r.logger.Info("failed request", "err", err, "failures", failures, "retryIn", delay, "circuitOpen", open)
+	} else {
+		r.logger.Error("poll error", "err", err, "failures", failures, "retryIn", delay, "circuitOpen", open)
+	}
+
+	return delay
+}
+
+func (r *LoopRunner) recordPoll(err error) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		if r.status.ConsecutiveFailures > 0 {
+			r.logger.Info("recovered", "failures", r.status.ConsecutiveFailures)
 		}
+		r.status.LastSuccess = time.Now()
+		r.status.FailingSince = time.Time{}
+		r.status.ConsecutiveFailures = 0
+		return 0
+	}
+
+	if r.status.ConsecutiveFailures == 0 {
+		r.status.FailingSince = time.Now()
 	}
+	r.status.ConsecutiveFailures++
+	r.status.LastError = err.Error()
+	return r.status.ConsecutiveFailures
 }
@@ -0,0 +1,163 @@
+package looper
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	hm "github.com/sprsquish/housemetrics/pkg"
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+// eagleVariables maps the local API's variables onto the metrics written by
+// the Rainforest endpoint, along with a scale to the endpoint's units.
+var eagleVariables = map[string]struct {
+	name  string
+	scale float64
+}{
+	"zigbee:InstantaneousDemand":       {"watts", 1000},
+	"zigbee:CurrentSummationDelivered": {"rainforest.summation_delivered", 1},
+	"zigbee:CurrentSummationReceived":  {"rainforest.summation_received", 1},
+	"zigbee:Price":                     {"rainforest.price", 1},
+}
+
+type eagleCommand struct {
+	XMLName       xml.Name `xml:"Command"`
+	Name          string
+	DeviceDetails *eagleDeviceRef `xml:",omitempty"`
+	Components    *eagleAll       `xml:",omitempty"`
+}
+
+type eagleDeviceRef struct {
+	HardwareAddress string
+}
+
+type eagleAll struct {
+	All string
+}
+
+type eagleDeviceDetails struct {
+	HardwareAddress  string
+	ModelId          string
+	ConnectionStatus string
+}
+
+// Eagle polls the local API of an Eagle-200 or Eagle-3 for the meters it's
+// joined to.
+type Eagle struct {
+	client *hm.HttpClient
+	logger *slog.Logger
+
+	addr        string
+	cloudID     string
+	installCode string
+	deviceMacId string
+
+	postURL *url.URL
+}
+
+func NewEagle(name string, flags *pflag.FlagSet, logger *slog.Logger, client *hm.HttpClient) hm.Looper {
+	e := Eagle{
+		client: client,
+		logger: logger,
+	}
+
+	flags.StringVar(&e.addr, fmt.Sprintf("%s.addr", name), "", "Eagle address, e.g. http://eagle-0012ab.local")
+	flags.StringVar(&e.cloudID, fmt.Sprintf("%s.cloudID", name), "", "Cloud ID, used as the username")
+	flags.StringVar(&e.installCode, fmt.Sprintf("%s.installCode", name), "", "Install code, used as the password")
+	flags.StringVar(&e.deviceMacId, fmt.Sprintf("%s.deviceMacId", name), "", "Eagle MAC to tag points with, as the uploader does")
+
+	return &e
+}
+
+func (e *Eagle) Init() {
+	postURL, err := url.Parse(strings.TrimSuffix(e.addr, "/") + "/cgi-bin/post_manager")
+	if err != nil {
+		e.logger.Error("couldn't parse URL", "err", err)
+		return
+	}
+	e.postURL = postURL
+}
+
+func (e *Eagle) Poll(ctx context.Context, store store.Client) error {
+	var list struct {
+		Devices []eagleDeviceDetails `xml:"Device"`
+	}
+	if err := e.send(ctx, eagleCommand{Name: "device_list"}, &list); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, dev := range list.Devices {
+		if dev.ModelId != "" && dev.ModelId != "electric_meter" {
+			continue
+		}
+		if err := e.queryMeter(ctx, store, dev.HardwareAddress); err != nil {
+			errs = append(errs, fmt.Errorf("meter %s: %w", dev.HardwareAddress, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (e *Eagle) queryMeter(ctx context.Context, store store.Client, addr string) error {
+	cmd := eagleCommand{
+		Name:          "device_query",
+		DeviceDetails: &eagleDeviceRef{HardwareAddress: addr},
+		Components:    &eagleAll{All: "Y"},
+	}
+
+	var rep struct {
+		DeviceDetails eagleDeviceDetails
+		Variables     []struct {
+			Name  string
+			Value string
+		} `xml:"Components>Component>Variables>Variable"`
+	}
+	if err := e.send(ctx, cmd, &rep); err != nil {
+		return err
+	}
+
+	ts := time.Now()
+	tags := map[string]string{"meter_mac_id": addr}
+	if e.deviceMacId != "" {
+		tags["device_mac_id"] = e.deviceMacId
+	}
+
+	connected := 0
+	if rep.DeviceDetails.ConnectionStatus == "Connected" {
+		connected = 1
+	}
+	store.Write(ctx, ts, "rainforest.connected", connected, tags)
+
+	for _, v := range rep.Variables {
+		metric, ok := eagleVariables[v.Name]
+		if !ok || v.Value == "" {
+			continue
+		}
+
+		val, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			e.logger.Error("could not parse variable", "err", err, "variable", v.Name, "value", v.Value)
+			continue
+		}
+		store.Write(ctx, ts, metric.name, val*metric.scale, tags)
+	}
+
+	return nil
+}
+
+func (e *Eagle) send(ctx context.Context, cmd eagleCommand, rep any) error {
+	return e.client.SendXML(ctx, e.logger, cmd, rep, func(req *http.Request) {
+		req.URL = e.postURL
+		req.SetBasicAuth(e.cloudID, e.installCode)
+	})
+}
@@ -2,10 +2,13 @@ package looper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -15,11 +18,26 @@ import (
 
 var (
 	authURL, _ = url.Parse("https://api.flumetech.com/oauth/token")
-	tags       = map[string]string{"unit": "gallons"}
 	timeFormat = "2006-01-02 15:04:05"
-	timeLoc, _ = time.LoadLocation("America/Los_Angeles")
 )
 
+const tokenExpiryMargin = 5 * time.Minute
+
+var flumeBuckets = map[string]struct{}{
+	"MIN": {},
+	"HR":  {},
+	"DAY": {},
+	"MON": {},
+	"YR":  {},
+}
+
+var flumeUnits = map[string]string{
+	"GALLONS":      "gallons",
+	"LITERS":       "liters",
+	"CUBIC_FEET":   "cubic_feet",
+	"CUBIC_METERS": "cubic_meters",
+}
+
 type Flume struct {
 	client *hm.HttpClient
 	logger *slog.Logger
@@ -30,10 +48,31 @@ type Flume struct {
 	password     string
 	userID       string
 	deviceID     string
+	maxCatchup   time.Duration
+	discoverFreq time.Duration
+	timezone     string
+	bucket       string
+	units        string
+
+	leaks      leakDetector
+	state      *hm.State
+	timeLoc    *time.Location
+	tags       map[string]string
+	devices    map[string]*flumeDevice
+	discovered time.Time
 
-	queryURL  *url.URL
-	tokenBody map[string]string
-	sinceTS   time.Time
+	// fetchMu serializes token requests. tknMu only guards token, so Status
+	// never waits on the auth endpoint.
+	fetchMu sync.Mutex
+	tknMu   sync.Mutex
+	token   flumeToken
+}
+
+type flumeToken struct {
+	access  string
+	refresh string
+	issued  time.Time
+	expires time.Time
 }
 
 func NewFlume(name string, flags *pflag.FlagSet, logger *slog.Logger, client *hm.HttpClient) hm.Looper {
@@ -46,39 +85,144 @@ func NewFlume(name string, flags *pflag.FlagSet, logger *slog.Logger, client *hm
 	flags.StringVar(&f.clientSecret, fmt.Sprintf("%s.clientSecret", name), "", "oAuth client secret")
 	flags.StringVar(&f.username, fmt.Sprintf("%s.username", name), "", "username")
 	flags.StringVar(&f.password, fmt.Sprintf("%s.password", name), "", "password")
-	flags.StringVar(&f.userID, fmt.Sprintf("%s.userID", name), "", "userID")
-	flags.StringVar(&f.deviceID, fmt.Sprintf("%s.deviceID", name), "", "deviceID")
+	flags.StringVar(&f.userID, fmt.Sprintf("%s.userID", name), "", "userID (read from the access token if empty)")
+	flags.StringVar(&f.deviceID, fmt.Sprintf("%s.deviceID", name), "", "Only poll this device (all sensors if empty)")
+	flags.DurationVar(&f.discoverFreq, fmt.Sprintf("%s.discoverFreq", name), 15*time.Minute, "How often to refresh the device list and status")
+	flags.DurationVar(&f.maxCatchup, fmt.Sprintf("%s.maxCatchup", name), 12*time.Hour, "How far back to resume from a saved cursor")
+	flags.StringVar(&f.timezone, fmt.Sprintf("%s.timezone", name), "America/Los_Angeles", "Timezone of the Flume account")
+	flags.StringVar(&f.bucket, fmt.Sprintf("%s.bucket", name), "MIN", "Query bucket: MIN, HR, DAY, MON or YR")
+	flags.StringVar(&f.units, fmt.Sprintf("%s.units", name), "GALLONS", "Units: GALLONS, LITERS, CUBIC_FEET or CUBIC_METERS")
+	f.leaks.registerFlags(name, flags)
 
 	return &f
 }
 
+func (f *Flume) SetState(state *hm.State) {
+	f.state = state
+}
+
 func (f *Flume) Init() {
-	f.sinceTS = time.Now()
-	f.queryURL, _ = url.Parse(fmt.Sprintf("https://api.flumetech.com/users/%s/devices/%s/query", f.userID, f.deviceID))
-	f.tokenBody = map[string]string{
-		"grant_type":    "password",
-		"client_id":     f.clientID,
-		"client_secret": f.clientSecret,
-		"username":      f.username,
-		"password":      f.password,
+	loc, err := time.LoadLocation(f.timezone)
+	if err != nil {
+		f.logger.Error("bad timezone, using UTC", "err", err, "timezone", f.timezone)
+		loc = time.UTC
+	}
+	f.timeLoc = loc
+
+	f.bucket = strings.ToUpper(f.bucket)
+	if _, ok := flumeBuckets[f.bucket]; !ok {
+		f.logger.Error("bad bucket, using MIN", "bucket", f.bucket)
+		f.bucket = "MIN"
+	}
+
+	f.units = strings.ToUpper(f.units)
+	unitTag, ok := flumeUnits[f.units]
+	if !ok {
+		f.logger.Error("bad units, using GALLONS", "units", f.units)
+		f.units, unitTag = "GALLONS", flumeUnits["GALLONS"]
+	}
+	f.tags = map[string]string{"unit": unitTag}
+
+	f.leaks.init(f.logger, f.timeLoc, f.bucket == "MIN")
+
+	// keep tracked devices, and their cursors, across re-inits
+	if f.devices == nil {
+		f.devices = map[string]*flumeDevice{}
+	}
+	for _, dev := range f.devices {
+		dev.leaks.configure(&f.leaks, f.logger.With("device", dev.id))
+	}
+	f.discovered = time.Time{}
+
+	// credentials may have changed
+	f.tknMu.Lock()
+	f.token = flumeToken{}
+	f.tknMu.Unlock()
+}
+
+// Status reports how old the cached access token is.
+func (f *Flume) Status() map[string]any {
+	f.tknMu.Lock()
+	defer f.tknMu.Unlock()
+
+	if f.token.access == "" {
+		return map[string]any{"token": "none"}
+	}
+	return map[string]any{
+		"tokenAge":       time.Since(f.token.issued).Round(time.Second).String(),
+		"tokenExpiresIn": time.Until(f.token.expires).Round(time.Second).String(),
 	}
 }
 
+// refreshDevices re-runs discovery when it's due. Failures are tolerated as
+// long as some devices are already known.
+func (f *Flume) refreshDevices(ctx context.Context, store store.Client, tkn string) error {
+	if time.Since(f.discovered) < f.discoverFreq {
+		return nil
+	}
+
+	if err := f.discover(ctx, store, tkn); err != nil {
+		if len(f.devices) == 0 {
+			if f.userID == "" || f.deviceID == "" {
+				return err
+			}
+			f.trackDevice(f.userID, f.deviceID, "")
+		}
+		f.logger.Error("device discovery failed", "err", err)
+		return nil
+	}
+
+	f.discovered = time.Now()
+	return nil
+}
+
 func (f *Flume) Poll(ctx context.Context, store store.Client) error {
 	tkn, err := f.getToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	nowTS := time.Now()
+	if err := f.refreshDevices(ctx, store, tkn); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, dev := range f.devices {
+		nowTS := time.Now()
+		readings, err := f.query(ctx, store, tkn, dev, dev.sinceTS, nowTS)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("device %s: %w", dev.id, err))
+			continue
+		}
+		dev.leaks.observe(ctx, store, readings, nowTS, dev.tags)
+
+		dev.sinceTS = nowTS
+		if err := f.state.Set("sinceTS."+dev.id, nowTS); err != nil {
+			f.logger.Error("could not save cursor", "err", err, "device", dev.id)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type flumeReading struct {
+	ts    time.Time
+	value any
+}
+
+// query writes usage between since and until, one point per bucket, and
+// returns what it wrote. The window starts at the beginning of the bucket
+// holding since, so a bucket's point is always its full usage so far.
+func (f *Flume) query(ctx context.Context, store store.Client, tkn string, dev *flumeDevice, since, until time.Time) ([]flumeReading, error) {
+	since = f.bucketStart(since)
 	reqData := map[string]any{
 		"queries": []map[string]string{{
-			"since_datetime": f.sinceTS.In(timeLoc).Format(timeFormat),
-			"until_datetime": nowTS.In(timeLoc).Format(timeFormat),
+			"since_datetime": since.In(f.timeLoc).Format(timeFormat),
+			"until_datetime": until.In(f.timeLoc).Format(timeFormat),
 			"request_id":     "query",
-			"bucket":         "MIN",
+			"bucket":         f.bucket,
 			"operation":      "SUM",
-			"units":          "GALLONS",
+			"units":          f.units,
 		}},
 	}
 
@@ -92,40 +236,159 @@ func (f *Flume) Poll(ctx context.Context, store store.Client) error {
 	}
 
 	if err := f.client.SendJSON(ctx, f.logger, reqData, &repData, func(req *http.Request) {
-		req.URL = f.queryURL
+		req.URL = dev.queryURL
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", tkn))
 	}); err != nil {
-		return err
+		var reqErr *hm.RequestError
+		if errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusUnauthorized {
+			f.dropToken()
+		}
+		return nil, err
 	}
 
+	var readings []flumeReading
+
 	for _, data := range repData.Data {
 		for _, entry := range data.Query {
 			ts := time.Now()
 			if entry.Datetime != "" {
-				ts, err = time.Parse(timeFormat, entry.Datetime)
+				var err error
+				ts, err = time.ParseInLocation(timeFormat, entry.Datetime, f.timeLoc)
 				if err != nil {
 					f.logger.Error("could not parse timestamp", "err", err, "entry", entry)
 				}
 			}
-			store.Write(ctx, ts, "flume.usage", entry.Value, tags)
+			store.Write(ctx, ts, "flume.usage", entry.Value, dev.tags)
+			readings = append(readings, flumeReading{ts: ts, value: entry.Value})
 		}
 	}
 
-	f.sinceTS = nowTS
+	return readings, nil
+}
 
-	return nil
+func (f *Flume) bucketStart(ts time.Time) time.Time {
+	ts = ts.In(f.timeLoc)
+	year, month, day := ts.Date()
+
+	switch f.bucket {
+	case "YR":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, f.timeLoc)
+	case "MON":
+		return time.Date(year, month, 1, 0, 0, 0, 0, f.timeLoc)
+	case "DAY":
+		return time.Date(year, month, day, 0, 0, 0, 0, f.timeLoc)
+	case "HR":
+		return time.Date(year, month, day, ts.Hour(), 0, 0, 0, f.timeLoc)
+	default:
+		return time.Date(year, month, day, ts.Hour(), ts.Minute(), 0, 0, f.timeLoc)
+	}
+}
+
+func (f *Flume) loadCursor(deviceID string) time.Time {
+	now := time.Now()
+
+	var since time.Time
+	found, err := f.state.Get("sinceTS."+deviceID, &since)
+	if !found && err == nil {
+		// cursor saved before devices were discovered
+		found, err = f.state.Get("sinceTS", &since)
+	}
+	if err != nil {
+		f.logger.Error("could not load cursor", "err", err, "device", deviceID)
+	}
+	if !found || err != nil || since.After(now) {
+		return now
+	}
+
+	if oldest := now.Add(-f.maxCatchup); since.Before(oldest) {
+		f.logger.Warn("saved cursor too old, skipping ahead", "cursor", since, "resume", oldest, "device", deviceID)
+		return oldest
+	}
+
+	f.logger.Info("resuming from saved cursor", "cursor", since, "device", deviceID)
+	return since
+}
+
+// getToken returns the cached access token until shortly before it expires,
+// then tries the refresh token before falling back to the password grant.
+func (f *Flume) getToken(ctx context.Context) (string, error) {
+	f.fetchMu.Lock()
+	defer f.fetchMu.Unlock()
+
+	f.tknMu.Lock()
+	cached := f.token
+	f.tknMu.Unlock()
+
+	if cached.access != "" && time.Now().Before(cached.expires.Add(-tokenExpiryMargin)) {
+		return cached.access, nil
+	}
+
+	if cached.refresh != "" {
+		tkn, err := f.requestToken(ctx, map[string]string{
+			"grant_type":    "refresh_token",
+			"client_id":     f.clientID,
+			"client_secret": f.clientSecret,
+			"refresh_token": cached.refresh,
+		})
+		if err == nil {
+			f.setToken(tkn)
+			return tkn.access, nil
+		}
+		f.logger.Info("token refresh failed, logging in again", "err", err)
+	}
+
+	tkn, err := f.requestToken(ctx, map[string]string{
+		"grant_type":    "password",
+		"client_id":     f.clientID,
+		"client_secret": f.clientSecret,
+		"username":      f.username,
+		"password":      f.password,
+	})
+	f.setToken(tkn)
+	if err != nil {
+		return "", err
+	}
+	return tkn.access, nil
 }
 
-func (f *Flume) getToken(ctx context.Context) (tkn string, err error) {
+func (f *Flume) requestToken(ctx context.Context, body map[string]string) (flumeToken, error) {
 	var tknStruct struct {
 		Data []struct {
-			Token string `json:"access_token"`
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+			ExpiresIn    int64  `json:"expires_in"`
 		}
 	}
-	err = f.client.SendJSON(ctx, f.logger, f.tokenBody, &tknStruct, hm.URLOpt(authURL))
-	if err != nil {
-		return
+	if err := f.client.SendJSON(ctx, f.logger, body, &tknStruct, hm.URLOpt(authURL)); err != nil {
+		return flumeToken{}, err
 	}
 
-	return tknStruct.Data[0].Token, nil
+	if len(tknStruct.Data) == 0 || tknStruct.Data[0].AccessToken == "" {
+		return flumeToken{}, errors.New("token response had no access token")
+	}
+	data := tknStruct.Data[0]
+
+	now := time.Now()
+	return flumeToken{
+		access:  data.AccessToken,
+		refresh: data.RefreshToken,
+		issued:  now,
+		expires: now.Add(time.Duration(data.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (f *Flume) setToken(tkn flumeToken) {
+	f.tknMu.Lock()
+	defer f.tknMu.Unlock()
+
+	f.token = tkn
+}
+
+// dropToken forgets the access token after the API rejects it, keeping the
+// refresh token for the next attempt.
+func (f *Flume) dropToken() {
+	f.tknMu.Lock()
+	defer f.tknMu.Unlock()
+
+	f.token.access = ""
 }
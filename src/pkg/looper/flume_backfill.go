@@ -0,0 +1,128 @@
+package looper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	hm "github.com/sprsquish/housemetrics/pkg"
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+type backfillCursor struct {
+	From time.Time
+	To   time.Time
+	Next time.Time
+}
+
+type BackfillOptions struct {
+	// Page is the span of each query.
+	Page time.Duration
+	// Pause is the wait between queries, to stay under the API rate limit.
+	Pause time.Duration
+	// Retry governs waits after failed queries; the backfill gives up once
+	// the circuit opens.
+	Retry hm.RetryPolicy
+}
+
+// Backfill writes usage for every tracked sensor between from and to with the
+// original timestamps. Progress is saved after every page, so re-running the
+// same range resumes where the last run stopped.
+func (f *Flume) Backfill(ctx context.Context, store store.Client, from, to time.Time, opts BackfillOptions) error {
+	if !from.Before(to) {
+		return fmt.Errorf("backfill range is empty: %s to %s", from, to)
+	}
+
+	tkn, err := f.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f.refreshDevices(ctx, store, tkn); err != nil {
+		return err
+	}
+
+	for _, dev := range f.devices {
+		if err := f.backfillDevice(ctx, store, dev, from, to, opts); err != nil {
+			return fmt.Errorf("device %s: %w", dev.id, err)
+		}
+	}
+	return nil
+}
+
+func (f *Flume) backfillDevice(ctx context.Context, store store.Client, dev *flumeDevice, from, to time.Time, opts BackfillOptions) error {
+	logger := f.logger.With("device", dev.id)
+	cursorKey := "backfill." + dev.id
+
+	cursor := backfillCursor{From: from, To: to, Next: from}
+	var saved backfillCursor
+	if found, err := f.state.Get(cursorKey, &saved); err != nil {
+		return err
+	} else if found && saved.From.Equal(from) && saved.To.Equal(to) {
+		cursor.Next = saved.Next
+		logger.Info("resuming backfill", "next", cursor.Next)
+	}
+
+	failures := 0
+	for cursor.Next.Before(to) {
+		until := cursor.Next.Add(opts.Page)
+		if until.After(to) {
+			until = to
+		}
+
+		err := f.backfillPage(ctx, store, dev, cursor.Next, until)
+		if err != nil {
+			failures++
+			delay, open := opts.Retry.Delay(failures, err)
+			if open {
+				return fmt.Errorf("backfill stopped at %s: %w", cursor.Next, err)
+			}
+
+			logger.Info("backfill page failed", "err", err, "since", cursor.Next, "retryIn", delay)
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+			continue
+		}
+
+		failures = 0
+		logger.Info("backfilled", "since", cursor.Next, "until", until)
+
+		cursor.Next = until
+		if err := f.state.Set(cursorKey, cursor); err != nil {
+			logger.Error("could not save backfill cursor", "err", err)
+		}
+
+		if cursor.Next.Before(to) {
+			if err := sleep(ctx, opts.Pause); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// backfillPage returns once the page's points are stored, so the cursor never
+// moves past points still sitting in a queue.
+func (f *Flume) backfillPage(ctx context.Context, client store.Client, dev *flumeDevice, since, until time.Time) error {
+	tkn, err := f.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := f.query(ctx, client, tkn, dev, since, until); err != nil {
+		return err
+	}
+	return store.Flush(ctx, client)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package looper
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+const (
+	flumeBridge = 1
+	flumeSensor = 2
+)
+
+var batteryLevels = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
+}
+
+type flumeDevice struct {
+	id       string
+	tags     map[string]string
+	queryURL *url.URL
+	sinceTS  time.Time
+	leaks    leakDetector
+}
+
+// discover lists the account's devices, records their battery and connection
+// status, and starts tracking any new sensors.
+func (f *Flume) discover(ctx context.Context, store store.Client, tkn string) error {
+	userID := f.userID
+	if userID == "" {
+		var err error
+		if userID, err = jwtUserID(tkn); err != nil {
+			return err
+		}
+	}
+
+	devURL, _ := url.Parse(fmt.Sprintf("https://api.flumetech.com/users/%s/devices?location=true", userID))
+
+	var rep struct {
+		Data []struct {
+			ID           string `json:"id"`
+			Type         int    `json:"type"`
+			BatteryLevel string `json:"battery_level"`
+			Connected    bool   `json:"connected"`
+			Location     struct {
+				Name string `json:"name"`
+			} `json:"location"`
+		}
+	}
+	if err := f.client.GetJSON(ctx, f.logger, &rep, func(req *http.Request) {
+		req.URL = devURL
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", tkn))
+	}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, d := range rep.Data {
+		if f.deviceID != "" && d.ID != f.deviceID {
+			continue
+		}
+
+		devType := "sensor"
+		if d.Type == flumeBridge {
+			devType = "bridge"
+		}
+
+		statusTags := map[string]string{"device": d.ID, "location": d.Location.Name, "type": devType}
+		connected := 0
+		if d.Connected {
+			connected = 1
+		}
+		store.Write(ctx, now, "flume.device_connected", connected, statusTags)
+		if level, ok := batteryLevels[strings.ToLower(d.BatteryLevel)]; ok {
+			store.Write(ctx, now, "flume.battery_level", level, statusTags)
+		}
+
+		if d.Type == flumeSensor {
+			f.trackDevice(userID, d.ID, d.Location.Name)
+		}
+	}
+
+	if len(f.devices) == 0 {
+		return errors.New("no flume sensors found")
+	}
+	return nil
+}
+
+func (f *Flume) trackDevice(userID, id, location string) {
+	tags := map[string]string{"device": id}
+	for k, v := range f.tags {
+		tags[k] = v
+	}
+	if location != "" {
+		tags["location"] = location
+	}
+
+	if dev, ok := f.devices[id]; ok {
+		dev.tags = tags
+		return
+	}
+
+	dev := &flumeDevice{
+		id:      id,
+		tags:    tags,
+		sinceTS: f.loadCursor(id),
+	}
+	dev.queryURL, _ = url.Parse(fmt.Sprintf("https://api.flumetech.com/users/%s/devices/%s/query", userID, id))
+	dev.leaks.configure(&f.leaks, f.logger.With("device", id))
+
+	f.logger.Info("tracking device", "device", id, "location", location)
+	f.devices[id] = dev
+}
+
+// jwtUserID reads the user_id claim from an access token. The token is not
+// verified; it only came from the API.
+func jwtUserID(tkn string) (string, error) {
+	parts := strings.Split(tkn, ".")
+	if len(parts) != 3 {
+		return "", errors.New("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("could not decode token payload: %w", err)
+	}
+
+	var claims struct {
+		UserID json.Number `json:"user_id"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("could not parse token claims: %w", err)
+	}
+	if claims.UserID == "" {
+		return "", errors.New("access token has no user_id")
+	}
+
+	return claims.UserID.String(), nil
+}
@@ -0,0 +1,193 @@
+package looper
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+// leakDetector watches the per-minute usage stream for flow that never stops,
+// like a running toilet or a burst pipe, and for unusual overnight usage.
+type leakDetector struct {
+	logger *slog.Logger
+
+	window         time.Duration
+	minFlow        float64
+	overnight      string
+	overnightLimit float64
+
+	enabled    bool
+	loc        *time.Location
+	nightStart int
+	nightEnd   int
+
+	lastTS     time.Time
+	runStart   time.Time
+	nightTotal float64
+	suspected  map[string]bool
+}
+
+func (d *leakDetector) registerFlags(name string, flags *pflag.FlagSet) {
+	flags.DurationVar(&d.window, fmt.Sprintf("%s.leak.window", name), 90*time.Minute, "Continuous flow lasting this long is flagged as a leak (0 disables)")
+	flags.Float64Var(&d.minFlow, fmt.Sprintf("%s.leak.minFlow", name), 0, "Per-minute usage above this counts as flowing")
+	flags.StringVar(&d.overnight, fmt.Sprintf("%s.leak.overnight", name), "00:00-05:00", "Overnight window, 'HH:MM-HH:MM' local time")
+	flags.Float64Var(&d.overnightLimit, fmt.Sprintf("%s.leak.overnightLimit", name), 0, "Usage during the overnight window above this is flagged (0 disables)")
+}
+
+func (d *leakDetector) init(logger *slog.Logger, loc *time.Location, perMinute bool) {
+	d.logger = logger
+	d.loc = loc
+	d.enabled = perMinute
+	if !perMinute {
+		logger.Info("leak detection needs the MIN bucket, disabling")
+		return
+	}
+
+	start, end, err := parseClockRange(d.overnight)
+	if err != nil {
+		logger.Error("bad overnight window, disabling overnight check", "err", err, "window", d.overnight)
+		d.overnightLimit = 0
+	}
+	d.nightStart, d.nightEnd = start, end
+}
+
+// configure copies cfg's settings, keeping any flow already being tracked.
+func (d *leakDetector) configure(cfg *leakDetector, logger *slog.Logger) {
+	d.logger = logger
+	d.window = cfg.window
+	d.minFlow = cfg.minFlow
+	d.overnight = cfg.overnight
+	d.overnightLimit = cfg.overnightLimit
+	d.enabled = cfg.enabled
+	d.loc = cfg.loc
+	d.nightStart = cfg.nightStart
+	d.nightEnd = cfg.nightEnd
+}
+
+// observe folds readings, oldest first, into the flow state and writes the
+// current leak metrics at the newest complete minute. Readings were queried
+// up to until.
+func (d *leakDetector) observe(ctx context.Context, store store.Client, readings []flumeReading, until time.Time, tags map[string]string) {
+	if !d.enabled {
+		return
+	}
+
+	for _, r := range readings {
+		// minutes already folded in were complete when seen
+		if !r.ts.After(d.lastTS) {
+			continue
+		}
+		// the newest minute is still filling up; the next poll re-queries it
+		if r.ts.Add(time.Minute).After(until) {
+			break
+		}
+
+		val, ok := usageValue(r.value)
+		if !ok {
+			continue
+		}
+
+		contiguous := !d.lastTS.IsZero() && r.ts.Sub(d.lastTS) <= time.Minute
+		switch {
+		case val <= d.minFlow:
+			d.runStart = time.Time{}
+		case d.runStart.IsZero() || !contiguous:
+			d.runStart = r.ts
+		}
+
+		if d.inNight(r.ts) {
+			if !d.inNight(d.lastTS) {
+				d.nightTotal = 0
+			}
+			d.nightTotal += val
+		}
+
+		d.lastTS = r.ts
+	}
+
+	ts := d.lastTS
+	if ts.IsZero() {
+		return
+	}
+
+	flowMinutes := 0.0
+	if !d.runStart.IsZero() {
+		flowMinutes = ts.Sub(d.runStart).Minutes() + 1
+	}
+	store.Write(ctx, ts, "flume.continuous_flow_minutes", flowMinutes, tags)
+
+	continuous := d.window > 0 && flowMinutes >= d.window.Minutes()
+	d.writeSuspected(ctx, store, ts, "continuous", continuous, tags)
+
+	if d.overnightLimit > 0 {
+		overnight := d.inNight(ts) && d.nightTotal > d.overnightLimit
+		d.writeSuspected(ctx, store, ts, "overnight", overnight, tags)
+	}
+}
+
+func (d *leakDetector) writeSuspected(ctx context.Context, store store.Client, ts time.Time, rule string, suspected bool, tags map[string]string) {
+	ruleTags := map[string]string{"rule": rule}
+	for k, v := range tags {
+		ruleTags[k] = v
+	}
+
+	if d.suspected == nil {
+		d.suspected = map[string]bool{}
+	}
+	if suspected != d.suspected[rule] {
+		if suspected {
+			d.logger.Warn("leak suspected", "rule", rule)
+		} else {
+			d.logger.Info("leak cleared", "rule", rule)
+		}
+		d.suspected[rule] = suspected
+	}
+
+	val := 0
+	if suspected {
+		val = 1
+	}
+	store.Write(ctx, ts, "flume.leak_suspected", val, ruleTags)
+}
+
+func (d *leakDetector) inNight(ts time.Time) bool {
+	if ts.IsZero() {
+		return false
+	}
+
+	local := ts.In(d.loc)
+	minute := local.Hour()*60 + local.Minute()
+	if d.nightStart <= d.nightEnd {
+		return minute >= d.nightStart && minute < d.nightEnd
+	}
+	return minute >= d.nightStart || minute < d.nightEnd
+}
+
+func parseClockRange(val string) (int, int, error) {
+	var startH, startM, endH, endM int
+	if _, err := fmt.Sscanf(val, "%d:%d-%d:%d", &startH, &startM, &endH, &endM); err != nil {
+		return 0, 0, err
+	}
+	if startH > 23 || endH > 23 || startM > 59 || endM > 59 {
+		return 0, 0, fmt.Errorf("invalid clock range: %s", val)
+	}
+	return startH*60 + startM, endH*60 + endM, nil
+}
+
+func usageValue(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/url"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -15,17 +15,24 @@ import (
 	"github.com/sprsquish/housemetrics/pkg/store"
 )
 
-var eventTypes = map[string]struct{}{
-	"lum-full-avg": {},
-}
-
 type Particle struct {
 	client *hm.HttpClient
 	logger *slog.Logger
 
-	stream    string
-	auth      string
-	streamURL *url.URL
+	stream     string
+	auth       string
+	streamOpts hm.StreamOptions
+	events     []string
+	allow      []string
+	devicesAPI string
+	deviceFreq time.Duration
+
+	streamURL  *url.URL
+	devicesURL *url.URL
+	rules      []particleRule
+
+	devMu   sync.Mutex
+	devices map[string]particleDevice
 }
 
 func NewParticle(name string, flags *pflag.FlagSet, logger *slog.Logger, client *hm.HttpClient) hm.Looper {
@@ -36,6 +43,13 @@ func NewParticle(name string, flags *pflag.FlagSet, logger *slog.Logger, client
 
 	flags.StringVar(&p.stream, fmt.Sprintf("%s.stream", name), "https://api.particle.io/v1/devices/events", "Stream URL")
 	flags.StringVar(&p.auth, fmt.Sprintf("%s.auth", name), "", "Auth code")
+	flags.StringSliceVar(&p.events, fmt.Sprintf("%s.events", name), []string{"lum-full-avg"}, "Events to capture: '<event>[*][=<measurement>][:float|int|bool|json]'")
+	flags.StringSliceVar(&p.allow, fmt.Sprintf("%s.devices", name), nil, "Only capture events from these device IDs or names (all if empty)")
+	flags.StringVar(&p.devicesAPI, fmt.Sprintf("%s.devicesURL", name), "https://api.particle.io/v1/devices", "Device list URL")
+	flags.DurationVar(&p.deviceFreq, fmt.Sprintf("%s.deviceFreq", name), 15*time.Minute, "How often to refresh device names")
+	flags.DurationVar(&p.streamOpts.IdleTimeout, fmt.Sprintf("%s.idleTimeout", name), time.Minute, "Reconnect when the stream is silent this long (0 disables)")
+	flags.DurationVar(&p.streamOpts.Retry.Min, fmt.Sprintf("%s.reconnect.min", name), time.Second, "Initial reconnect delay")
+	flags.DurationVar(&p.streamOpts.Retry.Max, fmt.Sprintf("%s.reconnect.max", name), 2*time.Minute, "Maximum reconnect delay")
 
 	return &p
 }
@@ -47,25 +61,40 @@ func (p *Particle) Init() {
 		return
 	}
 	p.streamURL = streamURL
+
+	devicesURL, err := url.Parse(p.devicesAPI)
+	if err != nil {
+		p.logger.Error("couldn't parse devices URL", "err", err)
+		return
+	}
+	p.devicesURL = devicesURL
+
+	p.rules = p.rules[:0]
+	for _, spec := range p.events {
+		rule, err := parseParticleRule(spec)
+		if err != nil {
+			p.logger.Error("bad event rule, skipping", "err", err, "rule", spec)
+			continue
+		}
+		p.rules = append(p.rules, rule)
+	}
 }
 
 func (p *Particle) Poll(ctx context.Context, store store.Client) error {
-	eventChan, err := p.client.Events(ctx, p.logger, hm.URLOpt(p.streamURL))
+	stream, err := p.client.Events(ctx, p.logger, hm.URLOpt(p.streamURL), p.streamOpts)
 	if err != nil {
 		return err
 	}
 
-	for {
-		evt := <-eventChan
-		p.logger.Debug("event received", "event", evt)
+	devCtx, stopDevices := context.WithCancel(ctx)
+	defer stopDevices()
+	go p.refreshDevices(devCtx)
 
-		// channel closed
-		if evt == nil {
-			p.logger.Info("event channel closed")
-			return nil
-		}
+	for evt := range stream.C {
+		p.logger.Debug("event received", "event", evt)
 
-		if _, ok := eventTypes[evt.Type]; !ok {
+		rule, measurement, ok := matchParticleRule(p.rules, evt.Type)
+		if !ok && evt.Type != "spark/status" {
 			continue
 		}
 
@@ -78,10 +107,8 @@ func (p *Particle) Poll(ctx context.Context, store store.Client) error {
 			p.logger.Error("couldn't parse event data", "err", err, "event", evt)
 		}
 
-		tags := map[string]string{"coreid": data.CoreID}
-		val, err := strconv.ParseFloat(data.Value, 32)
-		if err != nil {
-			p.logger.Error("could not convert data value", "err", err, "data", data)
+		tags, ok := p.deviceTags(data.CoreID)
+		if !ok {
 			continue
 		}
 
@@ -90,6 +117,42 @@ func (p *Particle) Poll(ctx context.Context, store store.Client) error {
 			p.logger.Error("could not parse published_at", "err", err, "data", data)
 		}
 
-		store.Write(ctx, ts, fmt.Sprintf("particle.%s", evt.Type), val, tags)
+		if evt.Type == "spark/status" {
+			p.writeOnline(ctx, store, ts, data.Value, tags)
+			continue
+		}
+
+		vals, err := particleDecoders[rule.decoder](data.Value)
+		if err != nil {
+			p.logger.Error("could not decode data value", "err", err, "data", data, "decoder", rule.decoder)
+			continue
+		}
+
+		for field, val := range vals {
+			name := measurement
+			if field != "" {
+				name += "." + field
+			}
+			store.Write(ctx, ts, name, val, tags)
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	p.logger.Info("event stream closed")
+	return nil
+}
+
+func (p *Particle) writeOnline(ctx context.Context, store store.Client, ts time.Time, status string, tags map[string]string) {
+	var online int
+	switch status {
+	case "online":
+		online = 1
+	case "offline":
+	default:
+		// auto-update and other notices
+		return
 	}
+	store.Write(ctx, ts, "particle.device_online", online, tags)
 }
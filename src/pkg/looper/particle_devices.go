@@ -0,0 +1,79 @@
+package looper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type particleDevice struct {
+	name    string
+	product string
+}
+
+// refreshDevices reloads device names and products every deviceFreq until ctx
+// is done.
+func (p *Particle) refreshDevices(ctx context.Context) {
+	ticker := time.NewTicker(p.deviceFreq)
+	defer ticker.Stop()
+
+	for {
+		if err := p.loadDevices(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("could not load devices", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Particle) loadDevices(ctx context.Context) error {
+	var rep []struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		ProductID int    `json:"product_id"`
+	}
+	if err := p.client.GetJSON(ctx, p.logger, &rep, func(req *http.Request) {
+		req.URL = p.devicesURL
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", p.auth))
+	}); err != nil {
+		return err
+	}
+
+	devices := make(map[string]particleDevice, len(rep))
+	for _, d := range rep {
+		devices[d.ID] = particleDevice{name: d.Name, product: strconv.Itoa(d.ProductID)}
+	}
+
+	p.devMu.Lock()
+	p.devices = devices
+	p.devMu.Unlock()
+
+	p.logger.Debug("loaded devices", "count", len(devices))
+	return nil
+}
+
+// deviceTags tags coreid with its name and product when they're known, and
+// reports whether the device passes the allowlist.
+func (p *Particle) deviceTags(coreID string) (map[string]string, bool) {
+	p.devMu.Lock()
+	dev, known := p.devices[coreID]
+	p.devMu.Unlock()
+
+	if len(p.allow) > 0 && !slices.Contains(p.allow, coreID) && (!known || !slices.Contains(p.allow, dev.name)) {
+		return nil, false
+	}
+
+	tags := map[string]string{"coreid": coreID}
+	if known {
+		tags["device"] = dev.name
+		tags["product"] = dev.product
+	}
+	return tags, true
+}
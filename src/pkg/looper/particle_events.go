@@ -0,0 +1,170 @@
+package looper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// particleDecoders turn an event's data into one or more values, keyed by a
+// measurement suffix ("" for the measurement itself).
+var particleDecoders = map[string]func(string) (map[string]any, error){
+	"float": decodeParticleFloat,
+	"int":   decodeParticleInt,
+	"bool":  decodeParticleBool,
+	"json":  decodeParticleJSON,
+}
+
+// particleRule captures events named pattern, or starting with it when prefix
+// is set.
+type particleRule struct {
+	pattern     string
+	prefix      bool
+	measurement string
+	decoder     string
+}
+
+// parseParticleRule reads "<event>[*][=<measurement>][:<decoder>]". A trailing
+// * matches by prefix, the measurement defaults to particle.<event> and the
+// decoder to float.
+func parseParticleRule(spec string) (particleRule, error) {
+	rule := particleRule{decoder: "float"}
+
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		rule.decoder = strings.ToLower(spec[i+1:])
+		spec = spec[:i]
+	}
+	if _, ok := particleDecoders[rule.decoder]; !ok {
+		return rule, fmt.Errorf("unknown decoder %q", rule.decoder)
+	}
+
+	spec, rule.measurement, _ = strings.Cut(spec, "=")
+	spec, rule.prefix = strings.CutSuffix(spec, "*")
+	if spec == "" {
+		return rule, fmt.Errorf("missing event name")
+	}
+	rule.pattern = spec
+
+	return rule, nil
+}
+
+// match returns the measurement for event, if the rule captures it. Prefix
+// rules with an explicit measurement append the rest of the event name.
+func (r particleRule) match(event string) (string, bool) {
+	if !r.prefix {
+		if event != r.pattern {
+			return "", false
+		}
+		if r.measurement != "" {
+			return r.measurement, true
+		}
+		return "particle." + event, true
+	}
+
+	rest, ok := strings.CutPrefix(event, r.pattern)
+	if !ok {
+		return "", false
+	}
+	if r.measurement == "" {
+		return "particle." + event, true
+	}
+	if rest = strings.Trim(rest, "/.-_"); rest == "" {
+		return r.measurement, true
+	}
+	return r.measurement + "." + rest, true
+}
+
+// matchParticleRule prefers an exact rule, then the longest matching prefix.
+func matchParticleRule(rules []particleRule, event string) (particleRule, string, bool) {
+	var (
+		best     particleRule
+		bestName string
+		found    bool
+	)
+
+	for _, rule := range rules {
+		name, ok := rule.match(event)
+		if !ok {
+			continue
+		}
+		if !rule.prefix {
+			return rule, name, true
+		}
+		if !found || len(rule.pattern) > len(best.pattern) {
+			best, bestName, found = rule, name, true
+		}
+	}
+
+	return best, bestName, found
+}
+
+func decodeParticleFloat(data string) (map[string]any, error) {
+	val, err := strconv.ParseFloat(strings.TrimSpace(data), 64)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"": val}, nil
+}
+
+func decodeParticleInt(data string) (map[string]any, error) {
+	val, err := strconv.ParseInt(strings.TrimSpace(data), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"": val}, nil
+}
+
+func decodeParticleBool(data string) (map[string]any, error) {
+	switch strings.ToLower(strings.TrimSpace(data)) {
+	case "on", "yes":
+		return map[string]any{"": true}, nil
+	case "off", "no":
+		return map[string]any{"": false}, nil
+	}
+
+	val, err := strconv.ParseBool(strings.TrimSpace(data))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"": val}, nil
+}
+
+// decodeParticleJSON turns each scalar field of a JSON object into a value.
+// Nested objects are flattened with "." and anything else is skipped.
+func decodeParticleJSON(data string) (map[string]any, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	vals := map[string]any{}
+	flattenParticleJSON("", obj, vals)
+	if len(vals) == 0 {
+		return nil, fmt.Errorf("no usable fields")
+	}
+	return vals, nil
+}
+
+func flattenParticleJSON(prefix string, obj map[string]any, vals map[string]any) {
+	for k, v := range obj {
+		key := prefix + k
+		switch v := v.(type) {
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				vals[key] = f
+			}
+		case bool:
+			vals[key] = v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				vals[key] = f
+			}
+		case map[string]any:
+			flattenParticleJSON(key+".", v, vals)
+		}
+	}
+}
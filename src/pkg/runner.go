@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -14,29 +17,127 @@ type HandlerFactory = func(string, *pflag.FlagSet, *slog.Logger, store.Client) h
 type LooperFactory = func(string, *pflag.FlagSet, *slog.Logger, *HttpClient) Looper
 
 type RunnerFactory struct {
-	Flags  *pflag.FlagSet
-	Client *HttpClient
-	Logger *slog.Logger
-	Store  store.Client
+	Flags   *pflag.FlagSet
+	Client  *HttpClient
+	Logger  *slog.Logger
+	Store   store.Client
+	Secrets *Secrets
+	Stats   *Stats
+	State   *State
 }
 
 func (f *RunnerFactory) MakeLooper(name string, defaultFreq time.Duration, factory LooperFactory) *LoopRunner {
+	return f.makeLooper(name, nil, defaultFreq, factory)
+}
+
+// MakeLoopers registers the default runner for name plus one runner for each
+// "<name>.<instance>" entry in instances. Instance runners get their own flags
+// under that prefix and tag every point with instance=<instance>.
+func (f *RunnerFactory) MakeLoopers(name string, instances []string, defaultFreq time.Duration, factory LooperFactory) []*LoopRunner {
+	runners := []*LoopRunner{f.MakeLooper(name, defaultFreq, factory)}
+
+	for _, instance := range instances {
+		instName, found := strings.CutPrefix(instance, name+".")
+		if !found {
+			continue
+		}
+
+		tags := map[string]string{"instance": instName}
+		runners = append(runners, f.makeLooper(instance, tags, defaultFreq, factory))
+	}
+
+	return runners
+}
+
+func (f *RunnerFactory) makeLooper(name string, tags map[string]string, defaultFreq time.Duration, factory LooperFactory) *LoopRunner {
 	logger := f.Logger.With("looper", name)
-	looper := factory(name, f.Flags, logger, f.Client)
+
+	var looper Looper
+	owned := AddedFlags(f.Flags, func() {
+		looper = factory(name, f.Flags, logger, f.Client.instrumented(name, f.Stats))
+	})
+	if stateful, ok := looper.(Stateful); ok && f.State != nil {
+		stateful.SetState(f.State.Scope(name))
+	}
 
 	runner := LoopRunner{
-		name:   name,
-		logger: logger,
-		looper: looper,
+		name:    name,
+		logger:  logger,
+		looper:  looper,
+		secrets: f.Secrets,
+		owned:   owned,
+		stats:   f.Stats,
+		tags:    tags,
+
+		trigger:     make(chan struct{}, 1),
+		freqChanged: make(chan struct{}, 1),
 	}
 
 	f.Flags.DurationVar(&runner.pollFreq, fmt.Sprintf("%s.freq", name), defaultFreq, "Polling frequency")
 	f.Flags.BoolVar(&runner.enabled, fmt.Sprintf("%s.enabled", name), false, "Enable polling")
+	f.Flags.DurationVar(&runner.retry.Min, fmt.Sprintf("%s.backoff.min", name), 10*time.Second, "Initial wait after a failed poll")
+	f.Flags.DurationVar(&runner.retry.Max, fmt.Sprintf("%s.backoff.max", name), 10*time.Minute, "Max wait between failed polls")
+	f.Flags.IntVar(&runner.retry.Threshold, fmt.Sprintf("%s.breaker.threshold", name), 10, "Consecutive failures before backing off to the cooldown (0 disables)")
+	f.Flags.DurationVar(&runner.retry.Cooldown, fmt.Sprintf("%s.breaker.cooldown", name), 30*time.Minute, "Wait between polls while the circuit is open")
 
 	return &runner
 }
 
 func (f *RunnerFactory) MakeHandler(name string, factory HandlerFactory) http.Handler {
 	logger := f.Logger.With("looper", name)
-	return factory(name, f.Flags, logger, f.Store)
+
+	var handler http.Handler
+	owned := AddedFlags(f.Flags, func() {
+		handler = factory(name, f.Flags, logger, f.Store)
+	})
+
+	// handlers read their flags per request, so rotated secrets are swapped in
+	// while no request is running
+	var mu sync.RWMutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if f.Secrets != nil && f.Secrets.Stale(owned) {
+			mu.Lock()
+			if f.Secrets.Refresh(owned) {
+				logger.Info("secrets rotated")
+			}
+			mu.Unlock()
+		}
+
+		mu.RLock()
+		defer mu.RUnlock()
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		handler.ServeHTTP(rec, req)
+		f.Stats.Inc("webhook.requests", map[string]string{"endpoint": name, "code": strconv.Itoa(rec.code)})
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// AddedFlags returns the names of the flags register adds to flags. Prefixes
+// can't be used for this since "flume." also covers "flume.backyard.".
+func AddedFlags(flags *pflag.FlagSet, register func()) []string {
+	existing := map[string]bool{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		existing[flag.Name] = true
+	})
+
+	register()
+
+	var added []string
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if !existing[flag.Name] {
+			added = append(added, flag.Name)
+		}
+	})
+	return added
 }
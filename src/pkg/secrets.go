@@ -0,0 +1,181 @@
+package housemetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	envPrefix  = "env:"
+	filePrefix = "file:"
+)
+
+type fileSecret struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// Secrets resolves flag values given as env:VAR or file:/path references.
+// File-backed values are remembered so they can be re-read when rotated.
+type Secrets struct {
+	flags  *pflag.FlagSet
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	files map[string]*fileSecret
+}
+
+func NewSecrets(flags *pflag.FlagSet, logger *slog.Logger) *Secrets {
+	return &Secrets{
+		flags:  flags,
+		logger: logger.With("component", "secrets"),
+		files:  map[string]*fileSecret{},
+	}
+}
+
+// Resolve replaces every scalar flag holding a secret reference with the
+// referenced value.
+func (s *Secrets) Resolve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	s.flags.VisitAll(func(flag *pflag.Flag) {
+		if _, ok := flag.Value.(pflag.SliceValue); ok {
+			return
+		}
+
+		ref := flag.Value.String()
+		switch {
+		case strings.HasPrefix(ref, envPrefix):
+			name := strings.TrimPrefix(ref, envPrefix)
+			val, ok := os.LookupEnv(name)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: environment variable %s is not set", flag.Name, name))
+				return
+			}
+			if err := s.flags.Set(flag.Name, val); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", flag.Name, err))
+			}
+
+		case strings.HasPrefix(ref, filePrefix):
+			secret := &fileSecret{path: strings.TrimPrefix(ref, filePrefix)}
+			if err := s.load(flag.Name, secret); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", flag.Name, err))
+				return
+			}
+			s.files[flag.Name] = secret
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// Stale reports whether the file behind any of the named flags changed since
+// it was last read. Unlike Refresh it doesn't touch the flags.
+func (s *Secrets) Stale(names []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		secret, ok := s.files[name]
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(secret.path)
+		if err == nil && (!info.ModTime().Equal(secret.modTime) || info.Size() != secret.size) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh re-reads the file-backed secrets of the named flags whose files
+// changed since they were last read, and reports whether any value changed.
+func (s *Secrets) Refresh(names []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, name := range names {
+		secret, ok := s.files[name]
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(secret.path)
+		if err != nil {
+			s.logger.Error("could not stat secret", "err", err, "flag", name)
+			continue
+		}
+		if info.ModTime().Equal(secret.modTime) && info.Size() == secret.size {
+			continue
+		}
+
+		old := s.flags.Lookup(name).Value.String()
+		if err := s.load(name, secret); err != nil {
+			s.logger.Error("could not reload secret", "err", err, "flag", name)
+			continue
+		}
+
+		if s.flags.Lookup(name).Value.String() != old {
+			s.logger.Info("secret rotated", "flag", name)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Watch refreshes the named flags every interval until ctx is done, calling
+// onChange after any of them rotate. It's for components without a poll loop
+// of their own to refresh from. A zero interval disables it.
+func (s *Secrets) Watch(ctx context.Context, interval time.Duration, names []string, onChange func()) {
+	if interval <= 0 || len(names) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.Refresh(names) {
+				onChange()
+			}
+		}
+	}
+}
+
+func (s *Secrets) load(name string, secret *fileSecret) error {
+	info, err := os.Stat(secret.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(secret.path)
+	if err != nil {
+		return err
+	}
+
+	if err := s.flags.Set(name, strings.TrimRight(string(data), "\r\n")); err != nil {
+		return err
+	}
+
+	secret.modTime = info.ModTime()
+	secret.size = info.Size()
+	return nil
+}
@@ -0,0 +1,313 @@
+package housemetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const maxEventLine = 1024 * 1024
+
+var (
+	errStreamIdle = errors.New("event stream idle")
+	errNoContent  = errors.New("server asked not to reconnect")
+	errNotStream  = errors.New("not an event stream")
+)
+
+// Event is a single Server-Sent Event. Multiple data lines are joined with
+// "\n" and ID is the last event ID seen on the stream.
+type Event struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// StreamOptions controls how Events keeps a stream open.
+type StreamOptions struct {
+	// Reconnect when nothing, not even a keepalive comment, arrives within
+	// IdleTimeout. Zero disables.
+	IdleTimeout time.Duration
+
+	// Retry paces reconnects. A retry: field from the server raises the
+	// minimum delay.
+	Retry RetryPolicy
+}
+
+// EventStream delivers events from a Server-Sent Events endpoint. C is closed
+// when the context is done or the server refuses to continue the stream.
+type EventStream struct {
+	C <-chan *Event
+
+	err error
+}
+
+// Err reports why the stream stopped. It is only valid once C is closed, and
+// is nil if the context was cancelled.
+func (s *EventStream) Err() error {
+	return s.err
+}
+
+type sseConn struct {
+	client *HttpClient
+	log    *slog.Logger
+	opts   func(*http.Request)
+	stream StreamOptions
+	events chan<- *Event
+
+	lastID string
+	retry  time.Duration
+}
+
+// Events connects to a Server-Sent Events endpoint. Once connected, the stream
+// reconnects with Last-Event-ID after errors and idle timeouts. Events are
+// never dropped: reading stops while the consumer is busy.
+func (c *HttpClient) Events(ctx context.Context, log *slog.Logger, opts func(*http.Request), streamOpts StreamOptions) (*EventStream, error) {
+	events := make(chan *Event)
+	conn := &sseConn{
+		client: c,
+		log:    log,
+		opts:   opts,
+		stream: streamOpts,
+		events: events,
+	}
+
+	body, err := conn.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &EventStream{C: events}
+	go func() {
+		defer close(events)
+		s.err = conn.run(ctx, body)
+	}()
+
+	return s, nil
+}
+
+func (c *sseConn) run(ctx context.Context, body io.ReadCloser) error {
+	for {
+		err := c.read(ctx, body)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		for failures := 1; ; failures++ {
+			delay, _ := c.stream.Retry.Delay(failures, err)
+			delay = max(delay, c.retry)
+			c.log.Info("event stream lost, reconnecting", "err", err, "retryIn", delay)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			body, err = c.connect(ctx)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !retryableStream(err) {
+				return err
+			}
+		}
+	}
+}
+
+func (c *sseConn) connect(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.opts(req)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if c.lastID != "" {
+		req.Header.Set("Last-Event-ID", c.lastID)
+	}
+
+	rep, err := c.client.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if rep.StatusCode == http.StatusNoContent {
+		rep.Body.Close()
+		return nil, errNoContent
+	}
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		rep.Body.Close()
+		return nil, newRequestError(rep)
+	}
+	if ct := rep.Header.Get("Content-Type"); ct != "" {
+		if mt, _, _ := mime.ParseMediaType(ct); mt != "text/event-stream" {
+			rep.Body.Close()
+			return nil, fmt.Errorf("%w: %s", errNotStream, ct)
+		}
+	}
+
+	return rep.Body, nil
+}
+
+// read parses the stream until it ends, dispatching each complete event.
+func (c *sseConn) read(ctx context.Context, body io.ReadCloser) error {
+	defer body.Close()
+
+	var idle atomic.Bool
+	var timer *time.Timer
+	if c.stream.IdleTimeout > 0 {
+		timer = time.AfterFunc(c.stream.IdleTimeout, func() {
+			idle.Store(true)
+			body.Close()
+		})
+		defer timer.Stop()
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 4096), maxEventLine)
+	scanner.Split(scanEventLines)
+
+	// id only becomes the last event ID once its event is complete, so a
+	// reconnect never skips an event cut off mid-stream
+	var (
+		evtType string
+		id      = c.lastID
+		data    strings.Builder
+		hasData bool
+		first   = true
+	)
+
+	for scanner.Scan() {
+		if timer != nil {
+			timer.Reset(c.stream.IdleTimeout)
+		}
+
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		c.log.Debug("event line", "line", line)
+
+		if line == "" {
+			c.lastID = id
+			if hasData {
+				evt := &Event{
+					ID:   c.lastID,
+					Type: evtType,
+					Data: strings.TrimSuffix(data.String(), "\n"),
+				}
+				if evt.Type == "" {
+					evt.Type = "message"
+				}
+
+				// the consumer may take a while; that isn't the stream idling
+				if timer != nil {
+					timer.Stop()
+				}
+				select {
+				case c.events <- evt:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if timer != nil {
+					timer.Reset(c.stream.IdleTimeout)
+				}
+			}
+
+			evtType, hasData = "", false
+			data.Reset()
+			continue
+		}
+
+		// comment, usually a keepalive
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			evtType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				id = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				c.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	if idle.Load() {
+		return errStreamIdle
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func retryableStream(err error) bool {
+	if errors.Is(err, errNoContent) || errors.Is(err, errNotStream) {
+		return false
+	}
+
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		code := reqErr.StatusCode
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// scanEventLines splits on CRLF, LF or a lone CR.
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// a CR at the end of the buffer may be half of a CRLF
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,221 @@
+package housemetrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestScanEventLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"lf", "a\nb\n", []string{"a", "b"}},
+		{"crlf", "a\r\nb\r\n", []string{"a", "b"}},
+		{"cr", "a\rb\r", []string{"a", "b"}},
+		{"mixed", "a\r\nb\rc\n", []string{"a", "b", "c"}},
+		{"blank lines", "a\n\r\n\r\n", []string{"a", "", ""}},
+		{"cr then blank", "a\r\r", []string{"a", ""}},
+		{"no trailing newline", "a\nb", []string{"a", "b"}},
+		{"trailing cr", "a\r", []string{"a"}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// one byte per read puts every CR at the end of the buffer
+			scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+			scanner.Split(scanEventLines)
+
+			var got []string
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type sseServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	lastIDs  []string
+	handlers []func(w http.ResponseWriter)
+}
+
+// newSSEServer answers the nth connection with handlers[n] and any further
+// connections with a 401.
+func newSSEServer(t *testing.T, handlers ...func(w http.ResponseWriter)) *sseServer {
+	s := &sseServer{handlers: handlers}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		n := len(s.lastIDs)
+		s.lastIDs = append(s.lastIDs, req.Header.Get("Last-Event-ID"))
+		s.mu.Unlock()
+
+		if n >= len(s.handlers) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		s.handlers[n](w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *sseServer) connections() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.lastIDs)
+}
+
+func (s *sseServer) stream(t *testing.T, opts StreamOptions) *EventStream {
+	u, _ := url.Parse(s.URL)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	stream, err := NewHttpClient().Events(context.Background(), logger, URLOpt(u), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func collect(t *testing.T, stream *EventStream, each func()) []Event {
+	var events []Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case evt, ok := <-stream.C:
+			if !ok {
+				return events
+			}
+			events = append(events, *evt)
+			if each != nil {
+				each()
+			}
+		case <-timeout:
+			t.Fatal("stream did not end")
+		}
+	}
+}
+
+func TestEventsReconnect(t *testing.T) {
+	srv := newSSEServer(t,
+		func(w http.ResponseWriter) {
+			io.WriteString(w, "\uFEFF: keepalive\r\nretry: 1\r\n"+
+				"event: temp\r\nid: 1\r\ndata: first\r\ndata: second\r\n\r\n"+
+				"data: no id\r\r"+
+				"id: 2\ndata: partial")
+		},
+		func(w http.ResponseWriter) {
+			io.WriteString(w, "event: temp\nid: 3\ndata:{\"v\":1}\n\n")
+		},
+	)
+
+	stream := srv.stream(t, StreamOptions{Retry: RetryPolicy{Min: time.Millisecond, Max: time.Millisecond}})
+	got := collect(t, stream, nil)
+
+	want := []Event{
+		{ID: "1", Type: "temp", Data: "first\nsecond"},
+		{ID: "1", Type: "message", Data: "no id"},
+		{ID: "3", Type: "temp", Data: `{"v":1}`},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events:\ngot  %q\nwant %q", got, want)
+	}
+
+	// the partial event was never delivered, so its id must not be sent
+	if conns := srv.connections(); !slices.Equal(conns, []string{"", "1", "3"}) {
+		t.Errorf("Last-Event-ID per connection: got %q", conns)
+	}
+
+	var reqErr *RequestError
+	if err := stream.Err(); !errors.As(err, &reqErr) || reqErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Err: got %v, want a 401", err)
+	}
+}
+
+func TestEventsBackpressure(t *testing.T) {
+	const count = 2000
+
+	srv := newSSEServer(t, func(w http.ResponseWriter) {
+		for i := range count {
+			fmt.Fprintf(w, "id: %d\ndata: %d\n\n", i, i)
+		}
+	})
+
+	// a consumer slower than the idle timeout must not look like a dead stream
+	idle := 20 * time.Millisecond
+	stream := srv.stream(t, StreamOptions{
+		IdleTimeout: idle,
+		Retry:       RetryPolicy{Min: time.Millisecond, Max: time.Millisecond},
+	})
+
+	n := 0
+	got := collect(t, stream, func() {
+		if n++; n%200 == 0 {
+			time.Sleep(2 * idle)
+		}
+	})
+
+	if len(got) != count {
+		t.Fatalf("got %d events, want %d", len(got), count)
+	}
+	for i, evt := range got {
+		if evt.Data != fmt.Sprint(i) {
+			t.Fatalf("event %d: got data %q", i, evt.Data)
+		}
+	}
+
+	// one stream, then the reconnect after it ended
+	if conns := srv.connections(); len(conns) != 2 || conns[1] != fmt.Sprint(count-1) {
+		t.Errorf("Last-Event-ID per connection: got %q", conns)
+	}
+}
+
+func TestEventsIdleTimeout(t *testing.T) {
+	srv := newSSEServer(t,
+		func(w http.ResponseWriter) {
+			io.WriteString(w, "id: a\ndata: 1\n\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Second)
+		},
+		func(w http.ResponseWriter) {
+			io.WriteString(w, "data: 2\n\n")
+		},
+	)
+
+	stream := srv.stream(t, StreamOptions{
+		IdleTimeout: 50 * time.Millisecond,
+		Retry:       RetryPolicy{Min: time.Millisecond, Max: time.Millisecond},
+	})
+	got := collect(t, stream, nil)
+
+	if len(got) != 2 || got[0].Data != "1" || got[1].Data != "2" {
+		t.Errorf("events: got %q", got)
+	}
+	if conns := srv.connections(); len(conns) < 2 || conns[1] != "a" {
+		t.Errorf("Last-Event-ID per connection: got %q", conns)
+	}
+}
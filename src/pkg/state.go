@@ -0,0 +1,102 @@
+package housemetrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+)
+
+// State is a small file-backed key/value store loopers use to keep cursors
+// across restarts. Each key is a JSON file under the state directory. With no
+// directory configured nothing is persisted.
+type State struct {
+	dir    *string
+	prefix string
+	mu     *sync.Mutex
+}
+
+// Stateful loopers are handed their own scope of the state store before Init.
+type Stateful interface {
+	SetState(*State)
+}
+
+func NewState(flags *pflag.FlagSet) *State {
+	s := &State{dir: new(string), mu: &sync.Mutex{}}
+	flags.StringVar(s.dir, "state.dir", "", "Directory for persisted looper state (empty disables)")
+	return s
+}
+
+// Persistent reports whether values outlive the process.
+func (s *State) Persistent() bool {
+	return s != nil && *s.dir != ""
+}
+
+// Scope returns a view of the store whose keys are prefixed with name.
+func (s *State) Scope(name string) *State {
+	return &State{dir: s.dir, prefix: s.prefix + name + ".", mu: s.mu}
+}
+
+// Get decodes the value stored under key into v and reports whether it was
+// found.
+func (s *State) Get(key string, v any) (bool, error) {
+	path, err := s.path(key)
+	if path == "" || err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *State) Set(key string, v any) error {
+	path, err := s.path(key)
+	if path == "" || err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(*s.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *State) path(key string) (string, error) {
+	if s == nil || *s.dir == "" {
+		return "", nil
+	}
+
+	name := s.prefix + key
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid state key: %q", name)
+	}
+	return filepath.Join(*s.dir, name+".json"), nil
+}
@@ -0,0 +1,166 @@
+package housemetrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sprsquish/housemetrics/pkg/store"
+)
+
+const statsPrefix = "housemetrics."
+
+type Sample struct {
+	Name  string            `json:"name"`
+	Value any               `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+type stat struct {
+	name  string
+	tags  map[string]string
+	timer bool
+
+	count uint64
+	sum   time.Duration
+
+	// reset on every flush
+	winCount uint64
+	winSum   time.Duration
+	winMax   time.Duration
+}
+
+// Stats collects the exporter's own metrics. They're written to the store
+// under the housemetrics. prefix on every flush and can be read as JSON.
+type Stats struct {
+	mu         sync.Mutex
+	stats      map[string]*stat
+	collectors []func() []Sample
+}
+
+func NewStats() *Stats {
+	return &Stats{stats: map[string]*stat{}}
+}
+
+func (s *Stats) Inc(name string, tags map[string]string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.get(name, tags, false)
+	st.count++
+}
+
+func (s *Stats) Observe(name string, took time.Duration, tags map[string]string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.get(name, tags, true)
+	st.count++
+	st.sum += took
+	st.winCount++
+	st.winSum += took
+	st.winMax = max(st.winMax, took)
+}
+
+// Collect registers a func whose samples are included in every flush.
+func (s *Stats) Collect(fn func() []Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collectors = append(s.collectors, fn)
+}
+
+// Run flushes to client every interval until ctx is done.
+func (s *Stats) Run(ctx context.Context, client store.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flush(ctx, client)
+		}
+	}
+}
+
+func (s *Stats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	samples := s.samples(false)
+	slices.SortStableFunc(samples, func(a, b Sample) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+func (s *Stats) flush(ctx context.Context, client store.Client) {
+	now := time.Now()
+	for _, sample := range s.samples(true) {
+		client.Write(ctx, now, statsPrefix+sample.Name, sample.Value, sample.Tags)
+	}
+}
+
+// samples snapshots every stat. Timers report their mean and max over the
+// current window, which is reset when reset is set.
+func (s *Stats) samples(reset bool) []Sample {
+	s.mu.Lock()
+	var samples []Sample
+	for _, st := range s.stats {
+		samples = append(samples, Sample{Name: st.name, Value: st.count, Tags: st.tags})
+		if !st.timer {
+			continue
+		}
+		samples[len(samples)-1].Name += ".count"
+
+		if st.winCount > 0 {
+			mean := st.winSum.Seconds() / float64(st.winCount)
+			samples = append(samples,
+				Sample{Name: st.name, Value: mean, Tags: st.tags},
+				Sample{Name: st.name + ".max", Value: st.winMax.Seconds(), Tags: st.tags})
+		}
+
+		if reset {
+			st.winCount, st.winSum, st.winMax = 0, 0, 0
+		}
+	}
+	collectors := slices.Clone(s.collectors)
+	s.mu.Unlock()
+
+	for _, collect := range collectors {
+		samples = append(samples, collect()...)
+	}
+	return samples
+}
+
+// get returns the stat for name and tags, creating it if needed. Callers must
+// hold mu.
+func (s *Stats) get(name string, tags map[string]string, timer bool) *stat {
+	keys := slices.Sorted(maps.Keys(tags))
+	key := name
+	for _, k := range keys {
+		key += fmt.Sprintf(",%s=%s", k, tags[k])
+	}
+
+	st, ok := s.stats[key]
+	if !ok {
+		st = &stat{name: name, tags: maps.Clone(tags), timer: timer}
+		s.stats[key] = st
+	}
+	return st
+}
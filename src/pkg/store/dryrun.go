@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type dryRunSeries struct {
+	name   string
+	tags   map[string]string
+	count  int
+	last   any
+	lastTS time.Time
+}
+
+// DryRunClient records writes instead of storing them and periodically logs
+// what would have been written, per measurement and tag set.
+type DryRunClient struct {
+	logger *slog.Logger
+
+	interval time.Duration
+
+	mu     sync.Mutex
+	series map[string]*dryRunSeries
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func NewDryRunClient(flags *pflag.FlagSet, logger *slog.Logger) *DryRunClient {
+	d := &DryRunClient{
+		logger: logger.With("store", "dryrun"),
+		series: map[string]*dryRunSeries{},
+	}
+
+	flags.DurationVar(&d.interval, "dry-run.interval", time.Minute, "How often to summarize dry run writes")
+
+	return d
+}
+
+func (d *DryRunClient) Init() {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.summarize()
+			}
+		}
+	}()
+}
+
+func (d *DryRunClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
+	key := name + promLabels(tags)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.series[key]
+	if !ok {
+		s = &dryRunSeries{name: name, tags: maps.Clone(tags)}
+		d.series[key] = s
+	}
+	s.count++
+	s.last = val
+	s.lastTS = ts
+}
+
+func (d *DryRunClient) Close() {
+	d.once.Do(func() {
+		if d.stop != nil {
+			close(d.stop)
+			<-d.done
+		}
+		d.summarize()
+	})
+}
+
+func (d *DryRunClient) summarize() {
+	d.mu.Lock()
+	series := d.series
+	d.series = map[string]*dryRunSeries{}
+	d.mu.Unlock()
+
+	if len(series) == 0 {
+		d.logger.Info("nothing would have been written")
+		return
+	}
+
+	total := 0
+	for _, key := range slices.Sorted(maps.Keys(series)) {
+		s := series[key]
+		total += s.count
+		d.logger.Info(
+			"would write",
+			"name", s.name,
+			"tags", s.tags,
+			"points", s.count,
+			"last", s.last,
+			"lastTS", s.lastTS)
+	}
+	d.logger.Info("dry run summary", "series", len(series), "points", total)
+}
@@ -1,15 +1,35 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
 	"github.com/spf13/pflag"
 )
 
+// replayKickGap limits how often successful writes trigger a spool replay.
+const replayKickGap = 10 * time.Second
+
+type InfluxStats struct {
+	Written uint64
+	Dropped uint64
+	Failed  uint64
+	Spooled uint64
+}
+
 type InfluxClient struct {
 	logger *slog.Logger
 
@@ -18,7 +38,37 @@ type InfluxClient struct {
 	token  string
 	org    string
 
-	client api.WriteAPIBlocking
+	batchSize     int
+	flushInterval time.Duration
+	queueSize     int
+
+	spoolDir      string
+	spoolMaxBytes int64
+	spoolMaxAge   time.Duration
+	spoolInterval time.Duration
+
+	clientMu sync.RWMutex
+	db       influxdb2.Client
+	client   api.WriteAPIBlocking
+	spool    *spool
+
+	replayKick chan struct{}
+	lastKick   atomic.Int64
+	stopReplay context.CancelFunc
+	replayDone chan struct{}
+
+	queue    chan *write.Point
+	flushReq chan chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	observe func(took time.Duration, points int, err error)
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+	spooled atomic.Uint64
+	lost    atomic.Uint64 // dropped and failed as of the last flush
 }
 
 func NewInfluxClient(flags *pflag.FlagSet, logger *slog.Logger) *InfluxClient {
@@ -30,6 +80,13 @@ func NewInfluxClient(flags *pflag.FlagSet, logger *slog.Logger) *InfluxClient {
 	flags.StringVar(&c.bucket, "influxdb.bucket", "", "database")
 	flags.StringVar(&c.token, "influxdb.token", "", "auth token")
 	flags.StringVar(&c.org, "influxdb.org", "", "database org")
+	flags.IntVar(&c.batchSize, "influxdb.batchSize", 0, "Points per batched write (0 writes each point synchronously)")
+	flags.DurationVar(&c.flushInterval, "influxdb.flushInterval", 10*time.Second, "Max time a point waits in a partial batch")
+	flags.IntVar(&c.queueSize, "influxdb.queueSize", 10000, "Points buffered before new writes are dropped")
+	flags.StringVar(&c.spoolDir, "influxdb.spool.dir", "", "Directory to spool failed writes to (empty disables)")
+	flags.Int64Var(&c.spoolMaxBytes, "influxdb.spool.maxBytes", 100<<20, "Max size of the spool before the oldest batches are dropped")
+	flags.DurationVar(&c.spoolMaxAge, "influxdb.spool.maxAge", 7*24*time.Hour, "Max age of spooled batches")
+	flags.DurationVar(&c.spoolInterval, "influxdb.spool.interval", time.Minute, "How often to retry spooled batches")
 
 	return c
 }
@@ -39,8 +96,49 @@ func (i *InfluxClient) Init() {
 		panic("trying to init an invalid store")
 	}
 
-	c := influxdb2.NewClient(i.dest, i.token)
-	i.client = c.WriteAPIBlocking(i.org, i.bucket)
+	i.db = influxdb2.NewClient(i.dest, i.token)
+	i.client = i.db.WriteAPIBlocking(i.org, i.bucket)
+
+	if i.spoolDir != "" {
+		sp, err := newSpool(i.spoolDir, i.spoolMaxBytes, i.spoolMaxAge, i.logger)
+		if err != nil {
+			panic(fmt.Sprintf("could not open spool: %s", err))
+		}
+		i.spool = sp
+
+		ctx, cancel := context.WithCancel(context.Background())
+		i.stopReplay = cancel
+		i.replayKick = make(chan struct{}, 1)
+		i.replayDone = make(chan struct{})
+		go i.runReplay(ctx)
+	}
+
+	if i.batchSize > 0 {
+		i.queue = make(chan *write.Point, i.queueSize)
+		i.flushReq = make(chan chan struct{})
+		i.done = make(chan struct{})
+		go i.runBatcher()
+	}
+}
+
+// ObserveWrites must be called before Init.
+func (i *InfluxClient) ObserveWrites(fn func(took time.Duration, points int, err error)) {
+	i.observe = fn
+}
+
+// Reload reconnects with the current flag values, such as a rotated token.
+func (i *InfluxClient) Reload() {
+	i.clientMu.Lock()
+	defer i.clientMu.Unlock()
+
+	if i.db == nil {
+		return
+	}
+
+	i.db.Close()
+	i.db = influxdb2.NewClient(i.dest, i.token)
+	i.client = i.db.WriteAPIBlocking(i.org, i.bucket)
+	i.logger.Info("reconnected with new credentials")
 }
 
 func (i *InfluxClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
@@ -54,7 +152,247 @@ func (i *InfluxClient) Write(ctx context.Context, ts time.Time, name string, val
 		"val", val,
 		"tags", tags)
 
-	if err := i.client.WritePoint(ctx, point); err != nil {
-		i.logger.Error("write error", "err", err)
+	if i.queue == nil {
+		i.writePoints(ctx, point)
+		return
+	}
+
+	select {
+	case i.queue <- point:
+	default:
+		i.dropped.Add(1)
+		i.logger.Error("write queue full, dropping point", "name", name)
+	}
+}
+
+// Flush writes any batched points. It fails if points were dropped or failed
+// since the last Flush, even if they were spooled for a later retry.
+func (i *InfluxClient) Flush(ctx context.Context) error {
+	if i.queue != nil {
+		done := make(chan struct{})
+		select {
+		case i.flushReq <- done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	lost := i.dropped.Load() + i.failed.Load()
+	if n := lost - i.lost.Swap(lost); n > 0 {
+		return fmt.Errorf("%d points dropped or failed", n)
+	}
+	return nil
+}
+
+// Close flushes any batched points. Writes must not be issued after Close.
+func (i *InfluxClient) Close() {
+	i.once.Do(func() {
+		if i.queue != nil {
+			close(i.queue)
+			<-i.done
+		}
+
+		if i.spool != nil {
+			i.stopReplay()
+			<-i.replayDone
+		}
+
+		stats := i.Stats()
+		i.logger.Info("closed", "written", stats.Written, "dropped", stats.Dropped, "failed", stats.Failed, "spooled", stats.Spooled)
+	})
+}
+
+func (i *InfluxClient) Ping(ctx context.Context) error {
+	i.clientMu.RLock()
+	defer i.clientMu.RUnlock()
+
+	ok, err := i.db.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("influxdb at %s is not ready", i.dest)
+	}
+	return nil
+}
+
+func (i *InfluxClient) Stats() InfluxStats {
+	return InfluxStats{
+		Written: i.written.Load(),
+		Dropped: i.dropped.Load(),
+		Failed:  i.failed.Load(),
+		Spooled: i.spooled.Load(),
+	}
+}
+
+func (i *InfluxClient) Counters() map[string]uint64 {
+	stats := i.Stats()
+	return map[string]uint64{
+		"written": stats.Written,
+		"dropped": stats.Dropped,
+		"failed":  stats.Failed,
+		"spooled": stats.Spooled,
+	}
+}
+
+func (i *InfluxClient) runBatcher() {
+	defer close(i.done)
+
+	ticker := time.NewTicker(i.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*write.Point, 0, i.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		i.writePoints(context.Background(), batch...)
+		batch = make([]*write.Point, 0, i.batchSize)
+	}
+
+	for {
+		select {
+		case point, ok := <-i.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, point)
+			if len(batch) >= i.batchSize {
+				flush()
+			}
+
+		case done := <-i.flushReq:
+			// take in what was queued before the request
+			for n := len(i.queue); n > 0; n-- {
+				batch = append(batch, <-i.queue)
+				if len(batch) >= i.batchSize {
+					flush()
+				}
+			}
+			flush()
+			close(done)
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (i *InfluxClient) writePoints(ctx context.Context, points ...*write.Point) {
+	i.clientMu.RLock()
+	start := time.Now()
+	err := i.client.WritePoint(ctx, points...)
+	took := time.Since(start)
+	i.clientMu.RUnlock()
+
+	if i.observe != nil {
+		i.observe(took, len(points), err)
+	}
+
+	if err != nil {
+		i.failed.Add(uint64(len(points)))
+		i.logger.Error("write error", "err", err, "points", len(points))
+		if retryableWrite(err) {
+			i.spoolPoints(points)
+		}
+		return
+	}
+	i.written.Add(uint64(len(points)))
+
+	// a write got through, so the database may be back; but don't re-read the
+	// spool on every write
+	if i.spool != nil && time.Since(time.Unix(0, i.lastKick.Load())) >= replayKickGap {
+		i.lastKick.Store(time.Now().UnixNano())
+		select {
+		case i.replayKick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// retryableWrite reports whether a failed write may succeed later. Rejections
+// such as a field type conflict fail the same way every time. Auth failures
+// are retried since a rotated token can fix them.
+func retryableWrite(err error) bool {
+	var httpErr *ihttp.Error
+	if !errors.As(err, &httpErr) || httpErr.StatusCode == 0 {
+		return true
+	}
+
+	switch code := httpErr.StatusCode; {
+	case code >= 500:
+		return true
+	case code == http.StatusTooManyRequests, code == http.StatusRequestTimeout:
+		return true
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+func (i *InfluxClient) spoolPoints(points []*write.Point) {
+	if i.spool == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := lp.NewEncoder(&buf)
+	enc.SetFieldTypeSupport(lp.UintSupport)
+	for _, point := range points {
+		if _, err := enc.Encode(point); err != nil {
+			i.logger.Error("could not encode point for spool", "err", err, "name", point.Name())
+		}
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	if err := i.spool.save(lines); err != nil {
+		i.logger.Error("spool error", "err", err, "points", len(points))
+		return
+	}
+	i.spooled.Add(uint64(len(points)))
+}
+
+func (i *InfluxClient) writeRecords(ctx context.Context, lines ...string) error {
+	i.clientMu.RLock()
+	defer i.clientMu.RUnlock()
+
+	return i.client.WriteRecord(ctx, lines...)
+}
+
+func (i *InfluxClient) runReplay(ctx context.Context) {
+	defer close(i.replayDone)
+
+	ticker := time.NewTicker(i.spoolInterval)
+	defer ticker.Stop()
+
+	for {
+		if i.spool.pending() {
+			n, err := i.spool.replay(ctx, i.writeRecords, retryableWrite)
+			if n > 0 {
+				i.written.Add(uint64(n))
+				i.logger.Info("replayed spooled points", "points", n)
+			}
+			if err != nil && ctx.Err() == nil {
+				i.logger.Debug("spool replay stopped", "err", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-i.replayKick:
+		}
 	}
 }
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Point struct {
+	TS    time.Time
+	Name  string
+	Value any
+	Tags  map[string]string
+}
+
+// MemoryClient keeps every write in memory.
+type MemoryClient struct {
+	mu     sync.Mutex
+	points []Point
+}
+
+func NewMemoryStore() *MemoryClient {
+	return &MemoryClient{}
+}
+
+func (m *MemoryClient) Init()  {}
+func (m *MemoryClient) Close() {}
+
+func (m *MemoryClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.points = append(m.points, Point{
+		TS:    ts,
+		Name:  name,
+		Value: val,
+		Tags:  maps.Clone(tags),
+	})
+}
+
+func (m *MemoryClient) Points() []Point {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.points)
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type point struct {
+	ctx  context.Context
+	ts   time.Time
+	name string
+	val  any
+	tags map[string]string
+
+	// flushed marks a flush request rather than a write
+	flushed chan struct{}
+}
+
+// WriteObserver is told how long each backend took to store a set of points,
+// and whether it failed.
+type WriteObserver func(backend string, took time.Duration, points int, err error)
+
+// SelfTimed is implemented by clients whose Write returns before the point is
+// stored, such as a batching client. They report their real writes instead.
+type SelfTimed interface {
+	ObserveWrites(fn func(took time.Duration, points int, err error))
+}
+
+type backend struct {
+	name    string
+	client  Client
+	logger  *slog.Logger
+	queue   chan point
+	done    chan struct{}
+	observe WriteObserver
+	dropped atomic.Uint64
+	flushed atomic.Uint64 // dropped as of the last flush
+}
+
+// MultiClient fans writes out to every selected backend. Each backend is fed
+// from its own queue so a slow or failing one can't hold up the rest.
+type MultiClient struct {
+	logger *slog.Logger
+
+	available map[string]Client
+	selected  []string
+	queueSize int
+	block     bool
+
+	observe WriteObserver
+
+	mu       sync.RWMutex
+	closed   bool
+	backends []*backend
+}
+
+func NewMultiClient(flags *pflag.FlagSet, logger *slog.Logger, available map[string]Client) *MultiClient {
+	m := &MultiClient{
+		logger:    logger.With("store", "multi"),
+		available: available,
+	}
+
+	names := make([]string, 0, len(available))
+	for name := range available {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	flags.StringSliceVar(&m.selected, "store", []string{"influxdb"}, fmt.Sprintf("Storage backends: %s", strings.Join(names, ", ")))
+	flags.IntVar(&m.queueSize, "store.queue", 1000, "Per-backend write queue size")
+
+	return m
+}
+
+// Observe must be called before Init.
+func (m *MultiClient) Observe(fn WriteObserver) {
+	m.observe = fn
+}
+
+// Block makes writes wait for room in a full queue rather than drop, for
+// loads that must not lose points.
+func (m *MultiClient) Block() {
+	m.block = true
+}
+
+func (m *MultiClient) Init() {
+	for _, name := range m.selected {
+		client, ok := m.available[name]
+		if !ok {
+			panic(fmt.Sprintf("unknown store: %s", name))
+		}
+
+		b := &backend{
+			name:    name,
+			client:  client,
+			logger:  m.logger.With("backend", name),
+			queue:   make(chan point, m.queueSize),
+			done:    make(chan struct{}),
+			observe: m.observe,
+		}
+
+		if timed, ok := client.(SelfTimed); ok && m.observe != nil {
+			timed.ObserveWrites(func(took time.Duration, points int, err error) {
+				m.observe(name, took, points, err)
+			})
+			b.observe = nil
+		}
+
+		if err := b.init(); err != nil {
+			b.logger.Error("init failed, disabling", "err", err)
+			continue
+		}
+
+		go b.run()
+		m.backends = append(m.backends, b)
+	}
+
+	if len(m.backends) == 0 {
+		panic("no usable store")
+	}
+}
+
+func (m *MultiClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
+	p := point{
+		ctx:  context.WithoutCancel(ctx),
+		ts:   ts,
+		name: name,
+		val:  val,
+		tags: tags,
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return
+	}
+
+	for _, b := range m.backends {
+		if m.block {
+			select {
+			case b.queue <- p:
+			case <-ctx.Done():
+				b.dropped.Add(1)
+			}
+			continue
+		}
+
+		select {
+		case b.queue <- p:
+		default:
+			b.dropped.Add(1)
+			b.logger.Error("queue full, dropping write", "name", name)
+		}
+	}
+}
+
+// Ping checks every backend that supports it.
+func (m *MultiClient) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range m.backends {
+		if pinger, ok := b.client.(Pinger); ok {
+			if err := pinger.Ping(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush waits for every backend to store the writes queued so far. It fails if
+// any backend dropped or lost writes since the last Flush.
+func (m *MultiClient) Flush(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return errors.New("store is closed")
+	}
+
+	var errs []error
+	for _, b := range m.backends {
+		if err := b.flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Counters reports each backend's queue drops along with any counters the
+// backend keeps itself, keyed "<backend>.<counter>".
+func (m *MultiClient) Counters() map[string]uint64 {
+	counters := map[string]uint64{}
+	for _, b := range m.backends {
+		counters[b.name+".queue_dropped"] = b.dropped.Load()
+		if counter, ok := b.client.(Counter); ok {
+			for k, v := range counter.Counters() {
+				counters[b.name+"."+k] = v
+			}
+		}
+	}
+	return counters
+}
+
+// Close drains every backend's queue before closing the backend itself.
+func (m *MultiClient) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range m.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			close(b.queue)
+			<-b.done
+			b.close()
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (b *backend) init() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	b.client.Init()
+	return nil
+}
+
+func (b *backend) run() {
+	defer close(b.done)
+	for p := range b.queue {
+		if p.flushed != nil {
+			close(p.flushed)
+			continue
+		}
+		b.write(p)
+	}
+}
+
+func (b *backend) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case b.queue <- point{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := Flush(ctx, b.client); err != nil {
+		return err
+	}
+
+	dropped := b.dropped.Load()
+	if lost := dropped - b.flushed.Swap(dropped); lost > 0 {
+		return fmt.Errorf("%d writes dropped", lost)
+	}
+	return nil
+}
+
+func (b *backend) write(p point) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error("write panic", "err", r, "name", p.name)
+		}
+	}()
+
+	start := time.Now()
+	b.client.Write(p.ctx, p.ts, p.name, p.val, p.tags)
+	if b.observe != nil {
+		b.observe(b.name, time.Since(start), 1, nil)
+	}
+}
+
+func (b *backend) close() {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error("close panic", "err", r)
+		}
+	}()
+
+	b.client.Close()
+}
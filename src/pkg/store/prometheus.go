@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	invalidPromChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	promEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type promSeries struct {
+	name   string
+	labels map[string]string
+	value  float64
+	seen   time.Time
+}
+
+// PromClient keeps the latest value of every series and serves them in the
+// Prometheus text exposition format.
+type PromClient struct {
+	logger *slog.Logger
+
+	ttl time.Duration
+
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+func NewPromClient(flags *pflag.FlagSet, logger *slog.Logger) *PromClient {
+	c := &PromClient{
+		logger: logger.With("store", "prometheus"),
+		series: map[string]*promSeries{},
+	}
+
+	flags.DurationVar(&c.ttl, "prometheus.ttl", 15*time.Minute, "Drop series not written within this window")
+
+	return c
+}
+
+func (p *PromClient) Init()  {}
+func (p *PromClient) Close() {}
+
+func (p *PromClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
+	fVal, ok := promValue(val)
+	if !ok {
+		p.logger.Debug("unsupported value", "name", name, "val", val)
+		return
+	}
+
+	name = invalidPromChars.ReplaceAllString(name, "_")
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		labels[invalidPromChars.ReplaceAllString(k, "_")] = v
+	}
+	key := name + promLabels(labels)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.series[key] = &promSeries{
+		name:   name,
+		labels: labels,
+		value:  fVal,
+		seen:   time.Now(),
+	}
+}
+
+func (p *PromClient) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	expiry := time.Now().Add(-p.ttl)
+	byName := map[string][]*promSeries{}
+	for key, s := range p.series {
+		if p.ttl > 0 && s.seen.Before(expiry) {
+			delete(p.series, key)
+			continue
+		}
+		byName[s.name] = append(byName[s.name], s)
+	}
+	p.mu.Unlock()
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		series := byName[name]
+		slices.SortFunc(series, func(a, b *promSeries) int {
+			return strings.Compare(promLabels(a.labels), promLabels(b.labels))
+		})
+
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", name)
+		for _, s := range series {
+			fmt.Fprintf(&sb, "%s%s %s\n", name, promLabels(s.labels), strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(sb.String()))
+}
+
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, k, promEscaper.Replace(labels[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promValue(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolExt    = ".lp"
+	rejectedExt = ".rejected"
+)
+
+// spool persists line protocol batches that failed to write so they can be
+// replayed, oldest first, once the database is reachable again. Each batch is
+// a file named after its creation time so the directory sorts in write order.
+type spool struct {
+	logger *slog.Logger
+
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration, logger *slog.Logger) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		logger:   logger.With("spool", dir),
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		s.logger.Info("recovered spooled batches", "batches", len(files))
+	}
+
+	return s, nil
+}
+
+func (s *spool) save(lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt)
+	path := filepath.Join(s.dir, name)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return s.enforceLimits()
+}
+
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	return err == nil && len(files) > 0
+}
+
+// replay writes spooled batches in order, stopping at the first failure that
+// may succeed later so nothing is written out of order or lost. Batches the
+// database rejects outright are set aside so they can't block the rest. It
+// returns the number of points replayed.
+func (s *spool) replay(ctx context.Context, write func(context.Context, ...string) error, retryable func(error) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enforceLimits(); err != nil {
+		return 0, err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, name := range files {
+		path := filepath.Join(s.dir, name)
+		lines, err := readLines(path)
+		if err != nil {
+			s.logger.Error("unreadable batch, discarding", "err", err, "file", name)
+			os.Remove(path)
+			continue
+		}
+
+		if len(lines) > 0 {
+			if err := write(ctx, lines...); err != nil {
+				if retryable(err) {
+					return replayed, err
+				}
+				s.reject(name, err)
+				continue
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			return replayed, err
+		}
+		replayed += len(lines)
+	}
+
+	return replayed, nil
+}
+
+// reject renames a batch out of the replay set, keeping it for inspection.
+// Callers must hold mu.
+func (s *spool) reject(name string, err error) {
+	path := filepath.Join(s.dir, name)
+	s.logger.Error("batch rejected, setting it aside", "err", err, "file", name+rejectedExt)
+	if err := os.Rename(path, path+rejectedExt); err != nil {
+		s.logger.Error("could not set batch aside, discarding", "err", err, "file", name)
+		os.Remove(path)
+	}
+}
+
+// enforceLimits drops batches older than maxAge, then the oldest batches
+// until the spool fits within maxBytes. Callers must hold mu.
+func (s *spool) enforceLimits() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	cutoff := time.Now().Add(-s.maxAge)
+
+	for i, name := range files {
+		path := filepath.Join(s.dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if s.maxAge > 0 && spoolTime(name).Before(cutoff) {
+			s.logger.Warn("discarding expired batch", "file", name)
+			os.Remove(path)
+			continue
+		}
+
+		sizes[i] = info.Size()
+		total += info.Size()
+	}
+
+	for i, name := range files {
+		if s.maxBytes <= 0 || total <= s.maxBytes {
+			break
+		}
+		if sizes[i] == 0 {
+			continue
+		}
+
+		s.logger.Warn("spool full, discarding batch", "file", name)
+		os.Remove(filepath.Join(s.dir, name))
+		total -= sizes[i]
+	}
+
+	return nil
+}
+
+func (s *spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolExt) {
+			files = append(files, entry.Name())
+		}
+	}
+	slices.Sort(files)
+
+	return files, nil
+}
+
+func spoolTime(name string) time.Time {
+	nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
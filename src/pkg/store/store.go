@@ -9,6 +9,7 @@ import (
 type Client interface {
 	Init()
 	Write(context.Context, time.Time, string, any, map[string]string)
+	Close()
 }
 
 type LogClient struct {
@@ -19,7 +20,8 @@ func NewLogStore(log *slog.Logger) *LogClient {
 	return &LogClient{log.With("store", "log")}
 }
 
-func (s *LogClient) Init() {}
+func (s *LogClient) Init()  {}
+func (s *LogClient) Close() {}
 func (s *LogClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
 	s.logger.Info(
 		"store",
@@ -28,3 +30,27 @@ func (s *LogClient) Write(ctx context.Context, ts time.Time, name string, val an
 		"val", val,
 		"tags", tags)
 }
+
+// Pinger is implemented by clients that can check their backend is reachable.
+type Pinger interface {
+	Ping(context.Context) error
+}
+
+// Counter is implemented by clients that count write outcomes.
+type Counter interface {
+	Counters() map[string]uint64
+}
+
+// Flusher is implemented by clients that buffer writes. Flush returns once
+// every earlier write was stored, or an error if some were lost.
+type Flusher interface {
+	Flush(context.Context) error
+}
+
+// Flush flushes client if it buffers writes.
+func Flush(ctx context.Context, client Client) error {
+	if flusher, ok := client.(Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
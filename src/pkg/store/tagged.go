@@ -0,0 +1,28 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// TaggedClient adds a fixed set of tags to every write. Tags passed to Write
+// take precedence.
+type TaggedClient struct {
+	Client
+	tags map[string]string
+}
+
+func WithTags(client Client, tags map[string]string) *TaggedClient {
+	return &TaggedClient{Client: client, tags: tags}
+}
+
+func (t *TaggedClient) Write(ctx context.Context, ts time.Time, name string, val any, tags map[string]string) {
+	merged := maps.Clone(t.tags)
+	maps.Copy(merged, tags)
+	t.Client.Write(ctx, ts, name, val, merged)
+}
+
+func (t *TaggedClient) Flush(ctx context.Context) error {
+	return Flush(ctx, t.Client)
+}